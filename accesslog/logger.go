@@ -0,0 +1,211 @@
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/request"
+	"github.com/rancher/authn-proxy/responsewriters"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaxSizeMB  = 100
+	defaultMaxBackups = 5
+)
+
+// Logger writes a line per request, separately from the proxy's own logs, in one of these formats:
+// - common, the Apache common log format
+// - combined, the Apache combined log format, which adds the referer and user agent
+// - json, an object per line
+// The Apache formats are followed by the latency in seconds, the backend and the request ID.
+type Logger struct {
+	format     string
+	sampleRate float64
+	exclude    []string
+
+	m sync.Mutex
+	w io.Writer
+}
+
+// entry is what is logged about a request.
+type entry struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"clientIP"`
+	User      string    `json:"user"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Latency   float64   `json:"latencySeconds"`
+	Backend   string    `json:"backend"`
+	Referer   string    `json:"referer"`
+	UserAgent string    `json:"userAgent"`
+	RequestID string    `json:"requestID"`
+}
+
+// New configures the access log. It returns nil if accesslog.output is unset, which disables it.
+func New(ctx context.Context) (*Logger, error) {
+	c := config.GetManager(ctx)
+	output := c.GetString("accesslog.output", "")
+	if output == "" {
+		return nil, nil
+	}
+
+	sampleRate, err := c.GetFloat("accesslog.sample.rate", 1)
+	if err != nil {
+		return nil, err
+	}
+	l := &Logger{
+		format:     c.GetString("accesslog.format", "combined"),
+		sampleRate: sampleRate,
+		exclude:    c.GetList("accesslog.exclude.paths"),
+	}
+
+	if output == "stdout" {
+		l.w = os.Stdout
+		return l, nil
+	}
+	maxSize, err := c.GetInt("accesslog.max.size", defaultMaxSizeMB)
+	if err != nil {
+		return nil, err
+	}
+	maxBackups, err := c.GetInt("accesslog.max.backups", defaultMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openRotatingFile(output, int64(maxSize)*1024*1024, maxBackups)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't open access log %v", output)
+	}
+	l.w = f
+	return l, nil
+}
+
+// Wrap logs the requests handled by next. A nil Logger logs nothing. Requests that fail are always logged, while
+// others are sampled at accesslog.sample.rate.
+func (l *Logger) Wrap(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if l.excluded(req.URL.Path) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		// The reverse proxy modifies the URL, so take what the client sent before passing the request on
+		e := entry{
+			Time:      time.Now(),
+			ClientIP:  request.ClientIP(req),
+			Method:    req.Method,
+			Path:      request.RedactURL(req.URL),
+			Protocol:  req.Proto,
+			Referer:   req.Referer(),
+			UserAgent: req.UserAgent(),
+		}
+		if id, ok := request.RequestIDFrom(req.Context()); ok {
+			e.RequestID = id
+		}
+
+		req, record := request.WithRecord(req)
+		w := responsewriters.Wrap(rw)
+		defer func() {
+			e.Status = w.Status()
+			if e.Status == 0 {
+				e.Status = http.StatusOK
+			}
+			if e.Status < http.StatusBadRequest && l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+				return
+			}
+			e.Bytes = w.BytesWritten()
+			e.Latency = time.Since(e.Time).Seconds()
+			e.Backend = record.Backend()
+			if user, ok := record.User(); ok {
+				e.User = user.Name
+			}
+			l.write(e)
+		}()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// excluded matches path against accesslog.exclude.paths, whose entries are exact paths or prefixes ending in *.
+func (l *Logger) excluded(path string) bool {
+	for _, pattern := range l.exclude {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logger) write(e entry) {
+	var line []byte
+	if l.format == "json" {
+		data, err := json.Marshal(e)
+		if err != nil {
+			logrus.Warnf("Couldn't encode access log entry: %v", err)
+			return
+		}
+		line = append(data, '\n')
+	} else {
+		line = []byte(apacheLine(e, l.format == "combined"))
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	if _, err := l.w.Write(line); err != nil {
+		logrus.Warnf("Couldn't write access log: %v", err)
+	}
+}
+
+// apacheLine formats e in the common or combined log format, followed by the latency, backend and request ID.
+func apacheLine(e entry, combined bool) string {
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	line := fmt.Sprintf("%v - %v [%v] %v %d %v",
+		dash(e.ClientIP),
+		dash(escape(e.User)),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.Path+" "+e.Protocol),
+		e.Status,
+		bytes)
+	if combined {
+		line += fmt.Sprintf(" %v %v", strconv.Quote(dash(e.Referer)), strconv.Quote(dash(e.UserAgent)))
+	}
+	return line + fmt.Sprintf(" %.3f %v %v\n", e.Latency, dash(e.Backend), dash(e.RequestID))
+}
+
+// dash stands in for empty fields, as in Apache logs.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escape keeps user names from breaking up the line.
+func escape(s string) string {
+	quoted := strconv.Quote(s)
+	return strings.Replace(quoted[1:len(quoted)-1], " ", `\x20`, -1)
+}
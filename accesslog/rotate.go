@@ -0,0 +1,73 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rotatingFile appends to a file, renaming it to <path>.1 once it reaches maxSize bytes and shifting older files up
+// to <path>.<maxBackups>. The oldest file is removed. It isn't safe for concurrent use.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	return f, f.open(os.O_APPEND)
+}
+
+func (f *rotatingFile) open(flag int) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|flag, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, fi.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep writing to the current file rather than lose lines
+			logrus.Warnf("Couldn't rotate access log %v: %v", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(f.backup(i), f.backup(i+1))
+	}
+	if f.maxBackups > 0 {
+		if err := os.Rename(f.path, f.backup(1)); err != nil {
+			return err
+		}
+	}
+	old := f.file
+	if err := f.open(os.O_TRUNC); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (f *rotatingFile) backup(i int) string {
+	return fmt.Sprintf("%v.%d", f.path, i)
+}
@@ -0,0 +1,167 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/rancher/authn-proxy/authnprovider"
+	"github.com/rancher/authn-proxy/certs"
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/lockout"
+	"github.com/rancher/authn-proxy/proxy"
+	"github.com/rancher/authn-proxy/request"
+	"github.com/sirupsen/logrus"
+)
+
+// State is what the admin API reports on. Certs is nil when https isn't enabled.
+type State struct {
+	Config         *config.Manager
+	Authenticators []authnprovider.Authenticator
+	Backend        *proxy.ReverseProxy
+	Certs          *certs.Store
+	Sessions       *Sessions
+	Lockouts       *lockout.Tracker
+}
+
+// Handler serves the admin API, which requires the bearer token in admin.token and is disabled while it's unset:
+// - GET /admin/config lists the effective config with the source of each value, with secrets redacted
+// - GET /admin/providers lists the authenticators and their health
+// - GET /admin/backend describes the backend and whether it can be reached
+// - GET /admin/certs describes the frontend certificates being served
+// - GET /admin/sessions lists the requests each user has in flight
+// - /admin/lockouts lists and clears lockouts, see lockout.Handler
+// - GET /admin/loglevel returns the log level, and PUT sets it to the request body until log.level next changes
+func Handler(s *State) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/admin/config", get(s.config))
+	mux.Handle("/admin/providers", get(s.providers))
+	mux.Handle("/admin/backend", get(s.backend))
+	mux.Handle("/admin/certs", get(s.certs))
+	mux.Handle("/admin/sessions", get(func(*http.Request) interface{} { return s.Sessions.Counts() }))
+	mux.Handle("/admin/lockouts", lockout.Handler(s.Lockouts))
+	mux.HandleFunc("/admin/loglevel", logLevel)
+	// Request IDs as on the frontend, so that what admin requests log can be told apart
+	return request.WithRequestIDHandler(s.authenticate(mux))
+}
+
+func (s *State) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		token := s.Config.GetString("admin.token", "")
+		if token == "" {
+			http.Error(rw, "admin API is disabled, set admin.token to enable it", http.StatusForbidden)
+			return
+		}
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			request.Log(req).Warnf("Rejecting admin request %v %v from %v with invalid token", req.Method, req.URL.Path, req.RemoteAddr)
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="authn-proxy admin"`)
+			http.Error(rw, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// get serves the result of f as JSON.
+func get(f func(req *http.Request) interface{}) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			rw.Header().Set("Allow", "GET")
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(f(req))
+	})
+}
+
+type configEntry struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	Reference string `json:"reference,omitempty"`
+}
+
+func (s *State) config(*http.Request) interface{} {
+	result := []configEntry{}
+	for _, e := range s.Config.Entries() {
+		value := e.Value
+		if e.Sensitive {
+			value = config.Redacted
+		}
+		result = append(result, configEntry{
+			Key:       e.Key,
+			Value:     value,
+			Source:    e.Source,
+			Reference: e.Reference,
+		})
+	}
+	return result
+}
+
+type provider struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (s *State) providers(*http.Request) interface{} {
+	result := []provider{}
+	for _, a := range s.Authenticators {
+		p := provider{Name: a.Name(), Healthy: true}
+		if err := authnprovider.Healthy(a); err != nil {
+			p.Healthy, p.Error = false, err.Error()
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+type backendStatus struct {
+	proxy.BackendInfo
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (s *State) backend(req *http.Request) interface{} {
+	status := backendStatus{BackendInfo: s.Backend.Backend(), Reachable: true}
+	if err := s.Backend.Ping(req); err != nil {
+		status.Reachable, status.Error = false, err.Error()
+	}
+	return status
+}
+
+func (s *State) certs(*http.Request) interface{} {
+	if s.Certs == nil {
+		return []certs.CertInfo{}
+	}
+	return s.Certs.Certificates()
+}
+
+func logLevel(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.Write([]byte(logrus.GetLevel().String() + "\n"))
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, 64))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := logrus.ParseLevel(strings.TrimSpace(string(body)))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logrus.SetLevel(level)
+		request.Log(req).Infof("Log level set to %v by admin API request from %v.", level, req.RemoteAddr)
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		rw.Header().Set("Allow", "GET, PUT")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/rancher/authn-proxy/request"
+)
+
+// Sessions counts the requests each user has in flight. Long running requests, like watches and exec, are counted
+// separately since they are the closest thing to sessions the proxy has.
+type Sessions struct {
+	m     sync.Mutex
+	users map[string]*SessionCount
+}
+
+// SessionCount is the number of requests a user has in flight.
+type SessionCount struct {
+	User        string `json:"user"`
+	Requests    int    `json:"requests"`
+	LongRunning int    `json:"longRunning"`
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{
+		users: map[string]*SessionCount{},
+	}
+}
+
+// Wrap counts the requests handled by next. It must run after authentication, since it keys on the user in the
+// request context.
+func (s *Sessions) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		user, ok := request.UserFrom(req.Context())
+		if !ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		longRunning := request.NewInfo(req).IsLongRunning()
+		s.add(user.Name, longRunning, 1)
+		defer s.add(user.Name, longRunning, -1)
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (s *Sessions) add(user string, longRunning bool, delta int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	count, ok := s.users[user]
+	if !ok {
+		count = &SessionCount{User: user}
+		s.users[user] = count
+	}
+	count.Requests += delta
+	if longRunning {
+		count.LongRunning += delta
+	}
+	if count.Requests == 0 {
+		delete(s.users, user)
+	}
+}
+
+// Counts lists the users with requests in flight, sorted by user.
+func (s *Sessions) Counts() []SessionCount {
+	s.m.Lock()
+	defer s.m.Unlock()
+	result := make([]SessionCount, 0, len(s.users))
+	for _, count := range s.users {
+		result = append(result, *count)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].User < result[j].User })
+	return result
+}
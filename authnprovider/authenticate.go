@@ -4,8 +4,24 @@ import "net/http"
 
 type Authenticator interface {
 	Authenticate(req *http.Request) (authed bool, user string, groups []string, err error)
+	// Name identifies the provider in logs and metrics
+	Name() string
 }
 
 func NewAuthnProvider() Authenticator {
 	return &hackAuthn{}
 }
+
+// HealthChecker is implemented by providers that depend on state or external systems, so that their failure
+// shows up in the proxy's health checks rather than as rejected logins.
+type HealthChecker interface {
+	Healthy() error
+}
+
+// Healthy returns the health of a, which is assumed healthy unless it implements HealthChecker.
+func Healthy(a Authenticator) error {
+	if h, ok := a.(HealthChecker); ok {
+		return h.Healthy()
+	}
+	return nil
+}
@@ -8,6 +8,10 @@ import (
 
 type hackAuthn struct{}
 
+func (a *hackAuthn) Name() string {
+	return "hack"
+}
+
 func (a *hackAuthn) Authenticate(req *http.Request) (bool, string, []string, error) {
 	user, groupsIMeanPassword, ok := req.BasicAuth()
 	if ok {
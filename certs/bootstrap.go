@@ -0,0 +1,202 @@
+package certs
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/config"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/cert"
+)
+
+const (
+	defaultBootstrapDir = "/var/lib/authn-proxy/certs"
+	caCertFile          = "ca.crt"
+	caKeyFile           = "ca.key"
+	servingCertFile     = "tls.crt"
+	servingKeyFile      = "tls.key"
+
+	// CABundlePath is where the frontend serves the bootstrap CA
+	CABundlePath = "/.well-known/authn-proxy/ca.crt"
+
+	// Serving certs are regenerated on startup when they're this close to expiring
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// Bootstrap provides a certificate when none is configured: it generates a CA and a serving certificate signed by
+// it, and persists both to frontend.ssl.bootstrap.dir so they survive restarts. The CA is kept as long as it is
+// readable; the serving certificate is regenerated when it is near expiry or doesn't cover the configured names.
+// The serving certificate covers localhost, the hostname, the POD_IP environment variable, the host the https
+// server listens on and the comma separated frontend.ssl.bootstrap.sans.
+func Bootstrap(ctx context.Context) (*Store, error) {
+	c := config.GetManager(ctx)
+	dir := c.GetString("frontend.ssl.bootstrap.dir", defaultBootstrapDir)
+
+	caCert, caKey, err := loadOrCreateCA(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't bootstrap CA")
+	}
+
+	altNames := bootstrapAltNames(c)
+	certPath, keyPath := filepath.Join(dir, servingCertFile), filepath.Join(dir, servingKeyFile)
+	if !servingCertValid(certPath, caCert, altNames) {
+		logrus.Infof("Generating serving certificate %v for %v %v", certPath, altNames.DNSNames, altNames.IPs)
+		if err := createServingCert(certPath, keyPath, caCert, caKey, altNames); err != nil {
+			return nil, errors.Wrap(err, "couldn't bootstrap serving certificate")
+		}
+	}
+
+	s := newStore(ctx)
+	k, err := s.keyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	s.def = k
+	s.caBundle = cert.EncodeCertPEM(caCert)
+	return s, nil
+}
+
+func loadOrCreateCA(dir string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+
+	certs, certErr := cert.CertsFromFile(certPath)
+	key, keyErr := cert.PrivateKeyFromFile(keyPath)
+	if certErr == nil && keyErr == nil {
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			return certs[0], rsaKey, nil
+		}
+	}
+	if !os.IsNotExist(certErr) || !os.IsNotExist(keyErr) {
+		logrus.Warnf("Couldn't load bootstrap CA from %v, generating a new one: %v", dir, firstErr(certErr, keyErr))
+	}
+
+	logrus.Infof("Generating CA %v", certPath)
+	caKey, err := cert.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	hostname, _ := os.Hostname()
+	caCert, err := cert.NewSelfSignedCACert(cert.Config{
+		CommonName: "authn-proxy-ca@" + hostname,
+	}, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := cert.WriteKey(keyPath, cert.EncodePrivateKeyPEM(caKey)); err != nil {
+		return nil, nil, err
+	}
+	if err := cert.WriteCert(certPath, cert.EncodeCertPEM(caCert)); err != nil {
+		return nil, nil, err
+	}
+	return caCert, caKey, nil
+}
+
+func createServingCert(certPath, keyPath string, caCert *x509.Certificate, caKey *rsa.PrivateKey, altNames cert.AltNames) error {
+	key, err := cert.NewPrivateKey()
+	if err != nil {
+		return err
+	}
+	servingCert, err := cert.NewSignedCert(cert.Config{
+		CommonName: "authn-proxy",
+		AltNames:   altNames,
+		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, key, caCert, caKey)
+	if err != nil {
+		return err
+	}
+
+	if err := cert.WriteKey(keyPath, cert.EncodePrivateKeyPEM(key)); err != nil {
+		return err
+	}
+	return cert.WriteCert(certPath, cert.EncodeCertPEM(servingCert))
+}
+
+// servingCertValid checks that an existing serving certificate can be reused.
+func servingCertValid(certPath string, caCert *x509.Certificate, altNames cert.AltNames) bool {
+	certs, err := cert.CertsFromFile(certPath)
+	if err != nil {
+		return false
+	}
+	c := certs[0]
+
+	if time.Now().Add(renewBefore).After(c.NotAfter) {
+		return false
+	}
+	if err := c.CheckSignatureFrom(caCert); err != nil {
+		return false
+	}
+	for _, name := range altNames.DNSNames {
+		if c.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	for _, ip := range altNames.IPs {
+		if c.VerifyHostname(ip.String()) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func bootstrapAltNames(c *config.Manager) cert.AltNames {
+	names := []string{"localhost", "127.0.0.1"}
+	if hostname, err := os.Hostname(); err == nil {
+		names = append(names, hostname)
+	}
+	if podIP := os.Getenv("POD_IP"); podIP != "" {
+		names = append(names, podIP)
+	}
+	if host, _, err := net.SplitHostPort(c.GetString("frontend.https.host", "")); err == nil && host != "" {
+		names = append(names, host)
+	}
+	names = append(names, c.GetList("frontend.ssl.bootstrap.sans")...)
+
+	altNames := cert.AltNames{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if ip := net.ParseIP(name); ip != nil {
+			if !ip.IsUnspecified() {
+				altNames.IPs = append(altNames.IPs, ip)
+			}
+		} else {
+			altNames.DNSNames = append(altNames.DNSNames, name)
+		}
+	}
+	return altNames
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CAHandler serves the bootstrap CA bundle at CABundlePath, without authentication, so clients can fetch it once
+// and verify the proxy from then on. All other requests are passed to next.
+func (s *Store) CAHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != CABundlePath || s.caBundle == nil {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/x-pem-file")
+		rw.Write(s.caBundle)
+	})
+}
@@ -0,0 +1,101 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// KeyPair serves a certificate and key loaded from files, reloading them when either file changes.
+// Tools like cert-manager don't write the two files at the same instant, so a cert that doesn't match its key is
+// expected for a moment during rotation: the previous pair keeps being served until a matching pair is loaded.
+type KeyPair struct {
+	use      string
+	certPath string
+	keyPath  string
+
+	m    sync.RWMutex
+	cert *tls.Certificate
+	leaf *x509.Certificate
+}
+
+// NewKeyPair loads the pair and watches the files until ctx is done. use names the pair in logs and metrics.
+func NewKeyPair(ctx context.Context, use, certPath, keyPath string) (*KeyPair, error) {
+	k, err := loadKeyPair(use, certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := config.GetManager(ctx)
+	for _, path := range []string{certPath, keyPath} {
+		if err := c.WatchFileContext(ctx, path, k.reload); err != nil {
+			return nil, errors.Wrapf(err, "couldn't watch %v", path)
+		}
+	}
+	return k, nil
+}
+
+// loadKeyPair loads a pair without watching it, for when the caller watches the files some other way.
+func loadKeyPair(use, certPath, keyPath string) (*KeyPair, error) {
+	k := &KeyPair{
+		use:      use,
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	return k, k.load()
+}
+
+// GetCertificate is for use as tls.Config.GetCertificate.
+func (k *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.m.RLock()
+	defer k.m.RUnlock()
+	return k.cert, nil
+}
+
+// Leaf returns the parsed certificate currently being served.
+func (k *KeyPair) Leaf() *x509.Certificate {
+	k.m.RLock()
+	defer k.m.RUnlock()
+	return k.leaf
+}
+
+func (k *KeyPair) reload() {
+	if err := k.load(); err != nil {
+		metrics.TLSCertReloads.WithLabelValues(k.use, "failure").Inc()
+		logrus.Warnf("Couldn't reload %v certificate, continuing to serve the previous one: %v", k.use, err)
+	}
+}
+
+func (k *KeyPair) load() error {
+	cert, err := tls.LoadX509KeyPair(k.certPath, k.keyPath)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't load key pair %v, %v", k.certPath, k.keyPath)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return errors.Wrapf(err, "couldn't parse certificate %v", k.certPath)
+	}
+	cert.Leaf = leaf
+
+	k.m.Lock()
+	defer k.m.Unlock()
+	if k.leaf != nil && bytes.Equal(k.leaf.Raw, leaf.Raw) {
+		return nil
+	}
+
+	if k.leaf != nil {
+		metrics.TLSCertReloads.WithLabelValues(k.use, "success").Inc()
+		logrus.Infof("Serving new %v certificate %v: serial %v, expires %v", k.use, k.certPath, leaf.SerialNumber, leaf.NotAfter)
+	}
+	k.cert = &cert
+	k.leaf = leaf
+	metrics.SetCertExpiry(k.use, k.certPath, []*x509.Certificate{leaf})
+	return nil
+}
@@ -0,0 +1,427 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/metrics"
+	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	certSuffix = ".crt"
+	keySuffix  = ".key"
+)
+
+// Store selects among several certificates by the SNI server name of each connection. Certificates come from:
+// - frontend.ssl.cert.path and frontend.ssl.key.path, the default pair
+// - frontend.ssl.certs, a comma separated list of cert:key path pairs
+// - frontend.ssl.certs.dir, a directory of <name>.crt and <name>.key files, rescanned when its contents change
+// A certificate is chosen by an exact match of the server name against its DNS names, then by a wildcard match.
+// Clients that don't send SNI, or names that match nothing, get the default pair, or failing that the first
+// certificate listed.
+type Store struct {
+	ctx    context.Context
+	c      *config.Manager
+	use    string
+	def    *KeyPair
+	listed []*KeyPair
+	dir    string
+
+	// caBundle is set for bootstrapped certificates, which clients can't verify without it
+	caBundle []byte
+
+	m        sync.RWMutex
+	dirPairs []*KeyPair
+
+	// loaded and watchedDirs are only used by configure, which config notifications never call concurrently. Both
+	// hold what's needed to stop watching the files once they're no longer configured.
+	loaded      map[string]*loadedPair
+	watchedDirs map[string]context.CancelFunc
+}
+
+type loadedPair struct {
+	pair *KeyPair
+	stop context.CancelFunc
+}
+
+// NewStore loads the configured frontend certificates. It returns nil if there are none. Changes to the keys
+// naming the certificates are applied without a restart.
+func NewStore(ctx context.Context) (*Store, error) {
+	s := newStore(ctx)
+	if err := s.configure(); err != nil {
+		return nil, err
+	}
+	if len(s.pairs()) == 0 {
+		return nil, nil
+	}
+
+	s.c.Watch(func([]config.Change) {
+		if err := s.configure(); err != nil {
+			logrus.Errorf("Couldn't apply %v certificate config, continuing to serve the previous certificates: %v", s.use, err)
+		}
+	}, "frontend.ssl.cert.path", "frontend.ssl.key.path", "frontend.ssl.certs", "frontend.ssl.certs.dir")
+	return s, nil
+}
+
+func newStore(ctx context.Context) *Store {
+	return &Store{
+		ctx:         ctx,
+		c:           config.GetManager(ctx),
+		use:         "frontend",
+		loaded:      map[string]*loadedPair{},
+		watchedDirs: map[string]context.CancelFunc{},
+	}
+}
+
+// configure loads the pairs named by the config, reusing those already loaded, and switches to them once they
+// have all loaded.
+func (s *Store) configure() error {
+	var def *KeyPair
+	if certPath := s.c.GetString("frontend.ssl.cert.path", ""); certPath != "" {
+		k, err := s.keyPair(certPath, s.c.GetString("frontend.ssl.key.path", ""))
+		if err != nil {
+			return err
+		}
+		def = k
+	}
+
+	var listed []*KeyPair
+	for _, pair := range s.c.GetList("frontend.ssl.certs") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("invalid frontend.ssl.certs entry %q, expected <cert path>:<key path>", pair)
+		}
+		k, err := s.keyPair(parts[0], parts[1])
+		if err != nil {
+			return err
+		}
+		listed = append(listed, k)
+	}
+
+	dir := s.c.GetString("frontend.ssl.certs.dir", "")
+	if _, ok := s.watchedDirs[dir]; dir != "" && !ok {
+		ctx, stop := context.WithCancel(s.ctx)
+		err := s.c.WatchFileContext(ctx, dir, func() {
+			if s.currentDir() == dir {
+				s.rescanDir()
+			}
+		})
+		if err != nil {
+			stop()
+			return errors.Wrapf(err, "couldn't watch %v", dir)
+		}
+		s.watchedDirs[dir] = stop
+	}
+
+	s.m.Lock()
+	previous := append([]*KeyPair{s.def}, s.listed...)
+	s.def, s.listed = def, listed
+	if dir != s.dir {
+		previous = append(previous, s.dirPairs...)
+		s.dir, s.dirPairs = dir, nil
+	}
+	s.m.Unlock()
+
+	if dir != "" {
+		if err := s.scanDir(); err != nil {
+			return err
+		}
+	}
+	s.forgetUnused(previous)
+	s.release(dir)
+	return nil
+}
+
+// keyPair returns the pair for the files, loading and watching them if they haven't been before.
+func (s *Store) keyPair(certPath, keyPath string) (*KeyPair, error) {
+	id := certPath + ":" + keyPath
+	if l, ok := s.loaded[id]; ok {
+		// Its expiry isn't reported while it's unused
+		metrics.SetCertExpiry(s.use, certPath, []*x509.Certificate{l.pair.Leaf()})
+		return l.pair, nil
+	}
+	ctx, stop := context.WithCancel(s.ctx)
+	k, err := NewKeyPair(ctx, s.use, certPath, keyPath)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	s.loaded[id] = &loadedPair{pair: k, stop: stop}
+	return k, nil
+}
+
+// release stops watching the pairs and directories that are no longer configured, so that they can be
+// forgotten.
+func (s *Store) release(dir string) {
+	inUse := map[*KeyPair]bool{}
+	for _, k := range s.pairs() {
+		inUse[k] = true
+	}
+	for id, l := range s.loaded {
+		if !inUse[l.pair] {
+			l.stop()
+			delete(s.loaded, id)
+		}
+	}
+	for watched, stop := range s.watchedDirs {
+		if watched != dir {
+			stop()
+			delete(s.watchedDirs, watched)
+		}
+	}
+}
+
+// forgetUnused stops reporting the expiry of pairs no longer served.
+func (s *Store) forgetUnused(previous []*KeyPair) {
+	inUse := map[*KeyPair]bool{}
+	for _, k := range s.pairs() {
+		inUse[k] = true
+	}
+	for _, k := range previous {
+		if k != nil && !inUse[k] {
+			logrus.Infof("No longer serving %v certificate %v", s.use, k.certPath)
+			metrics.TLSCertExpiry.Delete(s.use, k.certPath)
+		}
+	}
+}
+
+func (s *Store) currentDir() string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.dir
+}
+
+// GetCertificate is for use as tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	pairs, def := s.selection()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	if name != "" {
+		var wildcard *KeyPair
+		for _, k := range pairs {
+			leaf := k.Leaf()
+			for _, dnsName := range dnsNames(leaf) {
+				if dnsName == name {
+					return k.GetCertificate(hello)
+				}
+				if wildcard == nil && matchWildcard(dnsName, name) {
+					wildcard = k
+				}
+			}
+		}
+		if wildcard != nil {
+			return wildcard.GetCertificate(hello)
+		}
+	}
+
+	if def != nil {
+		return def.GetCertificate(hello)
+	}
+	if len(pairs) == 0 {
+		return nil, errors.New("no certificates available")
+	}
+	return pairs[0].GetCertificate(hello)
+}
+
+// Leaves returns the certificates currently being served.
+func (s *Store) Leaves() []*x509.Certificate {
+	var result []*x509.Certificate
+	for _, k := range s.pairs() {
+		result = append(result, k.Leaf())
+	}
+	return result
+}
+
+// CertInfo describes a certificate being served.
+type CertInfo struct {
+	Path      string    `json:"path"`
+	DNSNames  []string  `json:"dnsNames"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// Certificates describes the certificates currently being served, in order of preference.
+func (s *Store) Certificates() []CertInfo {
+	var result []CertInfo
+	for _, k := range s.pairs() {
+		leaf := k.Leaf()
+		if leaf == nil {
+			continue
+		}
+		result = append(result, CertInfo{
+			Path:      k.certPath,
+			DNSNames:  dnsNames(leaf),
+			Issuer:    leaf.Issuer.CommonName,
+			Serial:    leaf.SerialNumber.String(),
+			NotBefore: leaf.NotBefore,
+			NotAfter:  leaf.NotAfter,
+		})
+	}
+	return result
+}
+
+// pairs lists every pair in order of preference.
+func (s *Store) pairs() []*KeyPair {
+	pairs, _ := s.selection()
+	return pairs
+}
+
+// selection returns every pair in order of preference and the default pair, as of the same configuration.
+func (s *Store) selection() ([]*KeyPair, *KeyPair) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	result := append([]*KeyPair{}, s.listed...)
+	result = append(result, s.dirPairs...)
+	if s.def != nil {
+		result = append(result, s.def)
+	}
+	return result, s.def
+}
+
+func (s *Store) rescanDir() {
+	if err := s.scanDir(); err != nil {
+		logrus.Warnf("Couldn't rescan certificate directory %v: %v", s.currentDir(), err)
+	}
+}
+
+// scanDir loads every cert and key pair in the directory. Pairs that fail to load keep serving their previous
+// certificate, if they had one, and are otherwise skipped.
+func (s *Store) scanDir() error {
+	dir := s.currentDir()
+	names, err := pairNames(dir)
+	if err != nil {
+		return err
+	}
+
+	s.m.RLock()
+	existing := map[string]*KeyPair{}
+	for _, k := range s.dirPairs {
+		existing[k.certPath] = k
+	}
+	s.m.RUnlock()
+
+	var pairs []*KeyPair
+	for _, name := range names {
+		certPath := filepath.Join(dir, name+certSuffix)
+		keyPath := filepath.Join(dir, name+keySuffix)
+
+		if k, ok := existing[certPath]; ok {
+			delete(existing, certPath)
+			k.reload()
+			pairs = append(pairs, k)
+			continue
+		}
+
+		k, err := loadKeyPair(s.use, certPath, keyPath)
+		if err != nil {
+			logrus.Warnf("Skipping certificate %v: %v", certPath, err)
+			continue
+		}
+		logrus.Infof("Loaded %v certificate %v for %v", s.use, certPath, strings.Join(dnsNames(k.Leaf()), ", "))
+		pairs = append(pairs, k)
+	}
+
+	for path := range existing {
+		logrus.Infof("Certificate %v was removed, no longer serving it", path)
+		metrics.TLSCertExpiry.Delete(s.use, path)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	// The directory may have been reconfigured while it was scanned
+	if s.dir == dir {
+		s.dirPairs = pairs
+	}
+	return nil
+}
+
+// pairNames returns the names of the <name>.crt files in dir, sorted.
+func pairNames(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		// Skip hidden entries, such as the ..data link kubernetes uses to swap secret volume contents
+		if strings.HasSuffix(f.Name(), certSuffix) && !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, strings.TrimSuffix(f.Name(), certSuffix))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CheckConfig loads every configured frontend certificate without serving or watching it, returning all those that
+// can't be loaded, such as unreadable files or keys that don't match their certificate, together.
+func CheckConfig(c *config.Manager) error {
+	var errs []error
+	var pairs [][2]string
+	if certPath := c.GetString("frontend.ssl.cert.path", ""); certPath != "" {
+		pairs = append(pairs, [2]string{certPath, c.GetString("frontend.ssl.key.path", "")})
+	}
+	for _, pair := range c.GetList("frontend.ssl.certs") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			errs = append(errs, errors.Errorf("invalid frontend.ssl.certs entry %q, expected <cert path>:<key path>", pair))
+			continue
+		}
+		pairs = append(pairs, [2]string{parts[0], parts[1]})
+	}
+	if dir := c.GetString("frontend.ssl.certs.dir", ""); dir != "" {
+		names, err := pairNames(dir)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "couldn't read certificate directory %v", dir))
+		}
+		for _, name := range names {
+			pairs = append(pairs, [2]string{filepath.Join(dir, name+certSuffix), filepath.Join(dir, name+keySuffix)})
+		}
+	}
+
+	for _, pair := range pairs {
+		if _, err := tls.LoadX509KeyPair(pair[0], pair[1]); err != nil {
+			errs = append(errs, errors.Wrapf(err, "couldn't load key pair %v, %v", pair[0], pair[1]))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// dnsNames returns the names a certificate is valid for, falling back to the common name for certificates
+// without subject alternative names.
+func dnsNames(cert *x509.Certificate) []string {
+	if cert == nil {
+		return nil
+	}
+	names := cert.DNSNames
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		names = []string{cert.Subject.CommonName}
+	}
+	result := make([]string, len(names))
+	for i, n := range names {
+		result[i] = strings.ToLower(n)
+	}
+	return result
+}
+
+// matchWildcard matches a pattern like *.example.com against exactly one extra label, as browsers do.
+func matchWildcard(pattern, name string) bool {
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+	i := strings.Index(name, ".")
+	return i > 0 && name[i:] == pattern[1:]
+}
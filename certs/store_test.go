@@ -0,0 +1,118 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/authn-proxy/config"
+	"k8s.io/client-go/util/cert"
+)
+
+// writePair writes a self-signed certificate for host and its key to dir, returning their paths.
+func writePair(t *testing.T, dir, host string) (string, string) {
+	certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey(host, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, host+certSuffix), filepath.Join(dir, host+keySuffix)
+	if err := ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func servedName(t *testing.T, s *Store, serverName string) string {
+	c, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.DNSNames[0]
+}
+
+func TestStoreReleasesUnconfiguredPairs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authn-proxy-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aCert, aKey := writePair(t, dir, "a.example.com")
+	bCert, bKey := writePair(t, dir, "b.example.com")
+	certsDir := filepath.Join(dir, "more")
+	if err := os.Mkdir(certsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writePair(t, certsDir, "c.example.com")
+
+	c := config.GetManager(ctx)
+	c.SetSource("test", config.FlagPriority, map[string]string{
+		"frontend.ssl.cert.path": aCert,
+		"frontend.ssl.key.path":  aKey,
+		"frontend.ssl.certs.dir": certsDir,
+	})
+	s := newStore(ctx)
+	if err := s.configure(); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, s, ""); name != "a.example.com" {
+		t.Fatalf("default certificate is %v", name)
+	}
+	if name := servedName(t, s, "c.example.com"); name != "c.example.com" {
+		t.Fatalf("certificate for c.example.com is %v", name)
+	}
+
+	c.SetSource("test", config.FlagPriority, map[string]string{
+		"frontend.ssl.cert.path": bCert,
+		"frontend.ssl.key.path":  bKey,
+	})
+	if err := s.configure(); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, s, "c.example.com"); name != "b.example.com" {
+		t.Fatalf("certificate for c.example.com is %v after removing the directory", name)
+	}
+	if _, ok := s.loaded[bCert+":"+bKey]; len(s.loaded) != 1 || !ok {
+		t.Fatalf("loaded pairs are %v, expected only the new default", s.loaded)
+	}
+	if len(s.watchedDirs) != 0 {
+		t.Fatalf("still watching %v", s.watchedDirs)
+	}
+}
+
+func TestBootstrapStoreIsConfigurable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authn-proxy-bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config.GetManager(ctx).SetSource("test", config.FlagPriority, map[string]string{
+		"frontend.ssl.bootstrap.dir": dir,
+	})
+	s, err := Bootstrap(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.c == nil || s.ctx == nil || len(s.loaded) != 1 {
+		t.Fatalf("bootstrapped store wasn't built by newStore: %+v", s)
+	}
+	if _, err := s.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+		t.Fatal(err)
+	}
+}
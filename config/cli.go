@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	// EnvPrefix starts the environment variables that set config keys
+	EnvPrefix = "AUTHN_PROXY_"
+
+	envSource  = "environment"
+	flagSource = "flags"
+)
+
+// EnvName is the environment variable that sets key: frontend.https.host is set by AUTHN_PROXY_FRONTEND_HTTPS_HOST.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// Flags returns a flag for every known key, named after the key, such as --frontend.https.host. Keys with
+// patterns, like the per user ipfilter keys, can only be set in files.
+func Flags() []cli.Flag {
+	var flags []cli.Flag
+	for _, k := range Keys() {
+		if strings.Contains(k.Name, "*") {
+			continue
+		}
+		// The environment variable is listed for the help text, but read by LoadEnv so that it is a separate
+		// source from the flag
+		flags = append(flags, cli.StringFlag{
+			Name:   k.Name,
+			Usage:  k.Description,
+			EnvVar: EnvName(k.Name),
+		})
+	}
+	return flags
+}
+
+// LoadEnv sets known keys from their AUTHN_PROXY_* environment variables, overriding config files.
+func (m *Manager) LoadEnv() {
+	values := map[string]string{}
+	for _, k := range Keys() {
+		if strings.Contains(k.Name, "*") {
+			continue
+		}
+		if v, ok := os.LookupEnv(EnvName(k.Name)); ok {
+			values[k.Name] = v
+		}
+	}
+	m.SetSource(envSource, EnvPriority, values)
+}
+
+// LoadFlags sets keys from the flags returned by Flags that were given, overriding config files and the
+// environment.
+func (m *Manager) LoadFlags(c *cli.Context) {
+	values := map[string]string{}
+	for _, k := range Keys() {
+		if c.IsSet(k.Name) {
+			values[k.Name] = c.String(k.Name)
+		}
+	}
+	m.SetSource(flagSource, FlagPriority, values)
+}
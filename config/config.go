@@ -1,17 +1,12 @@
 package config
 
 import (
-	"bufio"
 	"context"
-	"io/ioutil"
 	"os"
-	"path"
-	"strings"
+	"sort"
 	"sync"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 type ParseType int
@@ -19,14 +14,18 @@ type ParseType int
 const (
 	SingleValueFile ParseType = iota
 	PropertiesFile
+	YAMLFile
+	JSONFile
 )
 
 // Manages watching property files and providing access to the the key/values
-// Two file formats are supported:
-// - simple properties files with newline terminated k=v
+// These file formats are supported:
+// - java properties files
+// - YAML and JSON files, whose nested keys are flattened to dotted keys
 // - a Single value files where the entire value of the file is value and the base name of the file is the key
-// Since files are watched for changes, no garauntees are made about precedence with regard to duplicate keys.
-// The last key loaded wins.
+// The values of each file are kept separately and merged into the effective config whenever one changes, so keys
+// removed from a file are removed from the config. When several files set a key, the file with the highest
+// precedence wins: see SetPrecedence.
 
 var m *Manager
 var mutex sync.Mutex
@@ -36,33 +35,57 @@ func GetManager(ctx context.Context) *Manager {
 	defer mutex.Unlock()
 
 	if m == nil {
-		m = &Manager{
-			ctx:          ctx,
-			m:            &sync.RWMutex{},
-			config:       map[string]string{},
-			watchedFiles: map[string]bool{},
-		}
+		m = newManager(ctx)
 	}
 
 	return m
 }
 
+func newManager(ctx context.Context) *Manager {
+	return &Manager{
+		ctx:          ctx,
+		m:            &sync.RWMutex{},
+		config:       map[string]string{},
+		origin:       map[string]string{},
+		sources:      map[string]*source{},
+		watchedFiles: map[string]bool{},
+		fileStatus:   map[string]FileStatus{},
+		sensitive:    map[string]bool{},
+		references:   map[string]string{},
+		unresolved:   map[string]error{},
+		secretFiles:  map[string]*secretFile{},
+	}
+}
+
 type Manager struct {
-	ctx          context.Context
-	m            *sync.RWMutex
+	ctx context.Context
+	m   *sync.RWMutex
+	// config is the effective config, merged from sources, and origin the source of each of its keys
 	config       map[string]string
+	origin       map[string]string
+	sources      map[string]*source
+	seq          int
+	precedence   []string
 	watchedFiles map[string]bool
+	fileStatus   map[string]FileStatus
+	// sensitive are the keys whose values are secrets, references the keys set by reference and the references,
+	// and unresolved the errors of those that couldn't be resolved
+	sensitive   map[string]bool
+	references  map[string]string
+	unresolved  map[string]error
+	secretFiles map[string]*secretFile
+	notifier    notifier
 }
 
-type watcher struct {
-	path      string
-	fsNotify  *fsnotify.Watcher
-	parseType ParseType
-	mgr       *Manager
+// FileStatus is the result of the last attempt to load a config file.
+type FileStatus struct {
+	Path      string
+	ParseType ParseType
+	Err       error
 }
 
 func (m *Manager) AddConfigFile(path string, parseType ParseType) error {
-	if parseType != SingleValueFile && parseType != PropertiesFile {
+	if parseType < SingleValueFile || parseType > JSONFile {
 		return errors.Errorf("Bad parseType %v", parseType)
 	}
 
@@ -74,95 +97,72 @@ func (m *Manager) AddConfigFile(path string, parseType ParseType) error {
 	m.watchedFiles[path] = true
 	m.m.Unlock()
 
-	fsWatcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return errors.Wrapf(err, "couldn't create watcher for file %v", path)
-	}
-
-	watcher := &watcher{
+	w := &watcher{
+		ctx:       m.ctx,
 		path:      path,
 		parseType: parseType,
-		fsNotify:  fsWatcher,
 		mgr:       m,
 	}
 
-	if err := watcher.parse(); err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
+	// A file that doesn't exist yet is loaded once it appears
+	if err := w.check(); err != nil && !os.IsNotExist(err) {
 		return errors.Wrap(err, "error parsing file")
 	}
 
-	// Add the file to be watched
-	if err := fsWatcher.Add(path); err != nil {
+	if err := w.start(); err != nil {
 		return errors.Wrap(err, "error watching file")
 	}
-
-	// Launch a go thread to watch the file
-	go watcher.run()
 	return nil
 }
 
-func (m *Manager) Get(key string) string {
-	m.m.RLock()
-	defer m.m.RUnlock()
-	v := m.config[key]
-	return v
+// WatchFile calls onChange whenever the file or directory at path changes. Unlike config files, the contents aren't
+// stored; this is for consumers such as TLS certificates that load and validate the file themselves. A directory
+// changes when any of the files in it do.
+func (m *Manager) WatchFile(path string, onChange func()) error {
+	return m.WatchFileContext(m.ctx, path, onChange)
 }
 
-func (w *watcher) run() {
-	for {
-		select {
-		case event := <-w.fsNotify.Events:
-			if event.Op == fsnotify.Remove {
-				w.fsNotify.Remove(event.Name)
-				w.fsNotify.Add(event.Name)
-			} else if event.Op == fsnotify.Write {
-				if err := w.parse(); err != nil {
-					logrus.Errorf("Error parsing config file %v: %v", w.path, err)
-				}
-			}
-		case <-w.mgr.ctx.Done():
-			w.fsNotify.Close()
-			return
-		}
+// WatchFileContext is WatchFile for consumers that stop using the file before the manager is done: the file is
+// watched until ctx is done.
+func (m *Manager) WatchFileContext(ctx context.Context, path string, onChange func()) error {
+	w := &watcher{
+		ctx:      ctx,
+		path:     path,
+		mgr:      m,
+		onChange: onChange,
 	}
-}
-
-func (w *watcher) parse() error {
-	if w.parseType == SingleValueFile {
-		bytes, err := ioutil.ReadFile(w.path)
-		if err != nil {
-			return errors.Wrap(err, "couldn't read single value file")
-		}
 
-		w.mgr.m.Lock()
-		defer w.mgr.m.Unlock()
-		w.mgr.config[path.Base(w.path)] = string(bytes)
-		return nil
-	}
+	// Consumers have already loaded the file, so record its state without notifying them
+	w.state, w.exists = w.read()
+	w.checked = true
+	return w.start()
+}
 
-	f, err := os.Open(w.path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	tmp := map[string]string{}
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 && !strings.HasPrefix(parts[0], "#") {
-			tmp[parts[0]] = parts[1]
-		}
+// Status reports the outcome of the last load of every config file added.
+func (m *Manager) Status() []FileStatus {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	var result []FileStatus
+	for _, status := range m.fileStatus {
+		result = append(result, status)
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
 
-	w.mgr.m.Lock()
-	defer w.mgr.m.Unlock()
-	for k, v := range tmp {
-		w.mgr.config[k] = v
+func (m *Manager) setStatus(path string, parseType ParseType, err error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.fileStatus[path] = FileStatus{
+		Path:      path,
+		ParseType: parseType,
+		Err:       err,
 	}
+}
 
-	return nil
+func (m *Manager) Get(key string) string {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	v := m.config[key]
+	return v
 }
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ParseTypeFor picks the format of a config file by its extension: .yaml and .yml files are YAML, .json files are
+// JSON and anything else is a properties file.
+func ParseTypeFor(path string) ParseType {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAMLFile
+	case ".json":
+		return JSONFile
+	}
+	return PropertiesFile
+}
+
+// parseJSON flattens a JSON object into dotted keys, see flatten.
+func parseJSON(data []byte) (map[string]string, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return nil, errors.New("expected an object at the top level")
+	}
+	values := map[string]string{}
+	return values, flatten("", doc, values)
+}
+
+// parseYAML flattens a YAML mapping into dotted keys, see flatten. Duplicate keys and files with more than one
+// document are rejected.
+func parseYAML(data []byte) (map[string]string, error) {
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	d.SetStrict(true)
+	var doc yamlValue
+	if err := d.Decode(&doc); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var next yamlValue
+	if err := d.Decode(&next); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("multiple documents aren't supported")
+	}
+	values := map[string]string{}
+	if doc.value == nil {
+		return values, nil
+	}
+	if _, ok := doc.value.(map[string]interface{}); !ok {
+		return nil, errors.New("expected a mapping at the top level")
+	}
+	return values, flatten("", doc.value, values)
+}
+
+// yamlValue decodes YAML into the same types as parseJSON, except that scalars are kept as the text they were
+// written as rather than resolved to numbers and booleans, so that values like 1.50 or 0644 reach the config
+// unchanged.
+type yamlValue struct {
+	value interface{}
+}
+
+func (y *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mapping map[string]yamlValue
+	if err := unmarshal(&mapping); err == nil {
+		// A null decodes to a nil map, and is kept as nil like in JSON
+		if mapping != nil {
+			m := make(map[string]interface{}, len(mapping))
+			for k, v := range mapping {
+				m[k] = v.value
+			}
+			y.value = m
+		}
+		return nil
+	}
+
+	var list []yamlValue
+	if err := unmarshal(&list); err == nil {
+		items := make([]interface{}, len(list))
+		for i, v := range list {
+			items[i] = v.value
+		}
+		y.value = items
+		return nil
+	}
+
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	y.value = s
+	return nil
+}
+
+// flatten turns nested objects into dotted keys, so that {"frontend": {"https": {"host": ":9443"}}} sets
+// frontend.https.host. Lists of scalars become comma separated values, and nulls empty values.
+func flatten(prefix string, v interface{}, values map[string]string) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			if err := flatten(k, child, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := scalarString(item)
+			if !ok {
+				return errors.Errorf("%v: only lists of plain values are supported", prefix)
+			}
+			items = append(items, s)
+		}
+		values[prefix] = strings.Join(items, ",")
+		return nil
+	}
+	s, _ := scalarString(v)
+	values[prefix] = s
+	return nil
+}
+
+func scalarString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
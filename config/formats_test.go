@@ -0,0 +1,183 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "empty document",
+			input: "# nothing here\n",
+			want:  map[string]string{},
+		},
+		{
+			name:  "nested mappings",
+			input: "frontend:\n  https:\n    host: :9443\n  http:\n    host: :9999\nlog.level: debug\n",
+			want:  map[string]string{"frontend.https.host": ":9443", "frontend.http.host": ":9999", "log.level": "debug"},
+		},
+		{
+			name:  "comments",
+			input: "---\n# comment\na: 1 # trailing comment\nb: x#y\nc: 'quoted # not a comment'\n...\n",
+			want:  map[string]string{"a": "1", "b": "x#y", "c": "quoted # not a comment"},
+		},
+		{
+			name:  "scalars are kept as strings",
+			input: "a: true\nb: 1.50\nc: ~\nd: null\ne:\n",
+			want:  map[string]string{"a": "true", "b": "1.50", "c": "", "d": "", "e": ""},
+		},
+		{
+			name:  "quoted scalars",
+			input: "a: 'it''s'\nb: \"tab\\there\"\nc: \"colon: inside\"\nd: don't\n'e: f': g\n",
+			want:  map[string]string{"a": "it's", "b": "tab\there", "c": "colon: inside", "d": "don't", "e: f": "g"},
+		},
+		{
+			name:  "colons without a following space are part of the value",
+			input: "host: https://example.com:8443\ntime: 12:30\naddress: '[::1]:9443'\n",
+			want:  map[string]string{"host": "https://example.com:8443", "time": "12:30", "address": "[::1]:9443"},
+		},
+		{
+			name:  "block sequences",
+			input: "groups:\n  - admins\n  - 'dev ops'\nusers:\n- alice\n- bob\n",
+			want:  map[string]string{"groups": "admins,dev ops", "users": "alice,bob"},
+		},
+		{
+			name:  "flow sequences",
+			input: "a: [x, 'y, z', \"w\"]\nb: []\nc: {}\n",
+			want:  map[string]string{"a": "x,y, z,w", "b": ""},
+		},
+		{
+			name:  "literal block scalar",
+			input: "cert: |\n  line one\n    indented\n\n  line three\nnext: x\n",
+			want:  map[string]string{"cert": "line one\n  indented\n\nline three\n", "next": "x"},
+		},
+		{
+			name:  "folded block scalar",
+			input: "text: >\n  one\n  two\n\n  three\n",
+			want:  map[string]string{"text": "one two\nthree\n"},
+		},
+		{
+			name:  "block scalar chomping",
+			input: "strip: |-\n  a\n\nkeep: |+\n  b\n\nclip: |\n  c\n\n\n",
+			want:  map[string]string{"strip": "a", "keep": "b\n\n", "clip": "c\n"},
+		},
+		{
+			name:  "block scalar keeps comment characters",
+			input: "script: |\n  # not a comment\n  echo hi # still not\n",
+			want:  map[string]string{"script": "# not a comment\necho hi # still not\n"},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "a:\r\n  b: 1\r\nc: |\r\n  x\r\n  y\r\n",
+			want:  map[string]string{"a.b": "1", "c": "x\ny\n"},
+		},
+		{
+			name:  "flow mappings",
+			input: "a: {b: 1, c: {d: x}}\n",
+			want:  map[string]string{"a.b": "1", "a.c.d": "x"},
+		},
+		{
+			name:  "anchors and aliases",
+			input: "a: &x value\nb: *x\n",
+			want:  map[string]string{"a": "value", "b": "value"},
+		},
+		{
+			name:  "tags and numbers keep their text",
+			input: "a: !!str 1\nmode: 0644\nhex: 0x1F\nratio: 1e3\n",
+			want:  map[string]string{"a": "1", "mode": "0644", "hex": "0x1F", "ratio": "1e3"},
+		},
+		{
+			name:  "explicit block scalar indentation",
+			input: "a: |2\n   x\n",
+			want:  map[string]string{"a": " x\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := parseYAML([]byte(tt.input))
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "top level sequence", input: "- a\n- b\n"},
+		{name: "top level scalar", input: "just text\n"},
+		{name: "tab indentation", input: "a:\n\tb: 1\n"},
+		{name: "unexpected indentation", input: "a: 1\n  b: 2\n"},
+		{name: "duplicate key", input: "a: 1\na: 2\n"},
+		{name: "empty key", input: ": 1\n"},
+		{name: "multiple documents", input: "a: 1\n---\nb: 2\n"},
+		{name: "nested flow sequence", input: "a: [[1], 2]\n"},
+		{name: "unterminated flow sequence", input: "a: [1, 2\n"},
+		{name: "unterminated single quoted string", input: "a: 'abc\n"},
+		{name: "invalid double quoted string", input: "a: \"abc\n"},
+		{name: "unknown alias", input: "a: *x\n"},
+		{name: "mapping in sequence", input: "a:\n  - b: 1\n"},
+		{name: "nested list", input: "a:\n  -\n    - 1\n"},
+		{name: "dedented block scalar line", input: "a: |\n    x\n  y\n"},
+	}
+
+	for _, tt := range tests {
+		if got, err := parseYAML([]byte(tt.input)); err == nil {
+			t.Errorf("%v: expected an error, got %q", tt.name, got)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	got, err := parseJSON([]byte(`{
+		"frontend": {"https": {"host": ":9443"}},
+		"ratelimit.user.qps": 2.5,
+		"debug": true,
+		"unset": null,
+		"groups": ["a", "b", 3]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"frontend.https.host": ":9443",
+		"ratelimit.user.qps":  "2.5",
+		"debug":               "true",
+		"unset":               "",
+		"groups":              "a,b,3",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, input := range []string{`[1, 2]`, `"text"`, `{"a": [{"b": 1}]}`, `{"a": 1`} {
+		if got, err := parseJSON([]byte(input)); err == nil {
+			t.Errorf("%v: expected an error, got %q", input, got)
+		}
+	}
+}
+
+func TestParseTypeFor(t *testing.T) {
+	for path, want := range map[string]ParseType{
+		"config.yaml":       YAMLFile,
+		"config.YML":        YAMLFile,
+		"config.json":       JSONFile,
+		"server.properties": PropertiesFile,
+		"config":            PropertiesFile,
+	} {
+		if got := ParseTypeFor(path); got != want {
+			t.Errorf("%v: got %v, want %v", path, got, want)
+		}
+	}
+}
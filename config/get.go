@@ -0,0 +1,107 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The typed getters return def when key is unset, and def with an error naming the key when its value is invalid.
+// Whitespace around values is ignored, since properties files keep trailing whitespace.
+
+func (m *Manager) GetString(key, def string) string {
+	if v := m.value(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func (m *Manager) GetBool(key string, def bool) (bool, error) {
+	v := m.value(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := parseBool(v)
+	if err != nil {
+		return def, invalid(key, err)
+	}
+	return b, nil
+}
+
+func (m *Manager) GetInt(key string, def int) (int, error) {
+	v := m.value(key)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def, invalid(key, err)
+	}
+	return i, nil
+}
+
+func (m *Manager) GetFloat(key string, def float64) (float64, error) {
+	v := m.value(key)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def, invalid(key, err)
+	}
+	return f, nil
+}
+
+func (m *Manager) GetDuration(key string, def time.Duration) (time.Duration, error) {
+	v := m.value(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, invalid(key, err)
+	}
+	return d, nil
+}
+
+// GetList splits a comma separated value, dropping whitespace around and empty entries.
+func (m *Manager) GetList(key string) []string {
+	return splitList(m.Get(key))
+}
+
+// GetURL requires an absolute URL. It returns nil when key is unset.
+func (m *Manager) GetURL(key string) (*url.URL, error) {
+	v := m.value(key)
+	if v == "" {
+		return nil, nil
+	}
+	u, err := parseURL(v)
+	if err != nil {
+		return nil, invalid(key, err)
+	}
+	return u, nil
+}
+
+// GetFilePath requires the file to exist. It returns "" when key is unset.
+func (m *Manager) GetFilePath(key string) (string, error) {
+	v := m.value(key)
+	if v == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(v); err != nil {
+		return "", invalid(key, err)
+	}
+	return v, nil
+}
+
+func (m *Manager) value(key string) string {
+	return strings.TrimSpace(m.Get(key))
+}
+
+func invalid(key string, err error) error {
+	return errors.Wrapf(err, "invalid value for %v", key)
+}
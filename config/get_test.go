@@ -0,0 +1,43 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGettersIgnoreTrailingWhitespace(t *testing.T) {
+	values, err := parseProperties([]byte("frontend.https.host=127.0.0.1:9443 \n" +
+		"ratelimit.user.burst=10\t\n" +
+		"ratelimit.user.qps=2.5 \n" +
+		"lockout.duration=1m  \n" +
+		"frontend.http.allow.cleartext=true \n" +
+		"ipfilter.group.system\\:masters.allow=10.0.0.0/8 \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newManager(context.Background())
+	m.SetSource("server.properties", FilePriority, values)
+
+	if got := m.GetString("frontend.https.host", ""); got != "127.0.0.1:9443" {
+		t.Errorf("GetString = %q", got)
+	}
+	if got, err := m.GetInt("ratelimit.user.burst", 0); err != nil || got != 10 {
+		t.Errorf("GetInt = %v, %v", got, err)
+	}
+	if got, err := m.GetFloat("ratelimit.user.qps", 0); err != nil || got != 2.5 {
+		t.Errorf("GetFloat = %v, %v", got, err)
+	}
+	if got, err := m.GetDuration("lockout.duration", 0); err != nil || got != time.Minute {
+		t.Errorf("GetDuration = %v, %v", got, err)
+	}
+	if got, err := m.GetBool("frontend.http.allow.cleartext", false); err != nil || !got {
+		t.Errorf("GetBool = %v, %v", got, err)
+	}
+	if got := m.GetList("ipfilter.group.system:masters.allow"); len(got) != 1 || got[0] != "10.0.0.0/8" {
+		t.Errorf("GetList = %q", got)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// keys is the schema of every key the proxy reads. Keys that aren't listed are reported as unknown.
+var keys = []Key{
+	{Name: "log.level", Type: String, Check: checkLogLevel, Description: "Log level: debug, info, warning or error"},
+	{Name: "log.format", Type: String, Check: oneOf("text", "json"), Description: "Log format: text or json"},
+
+	{Name: "token", Type: String, Sensitive: true, Description: "Service account token the proxy sends to the backend"},
+	{Name: "backend.scheme", Type: String, Check: oneOf("http", "https"), Description: "Scheme of the backend, in-cluster config is used if unset"},
+	{Name: "backend.host", Type: String, Description: "host:port of the backend, in-cluster config is used if unset"},
+	{Name: "backend.ca.cert.path", Type: FilePath, Description: "CA bundle to verify the backend with"},
+
+	{Name: "frontend.ssl.cert.path", Type: FilePath, Description: "Default frontend certificate"},
+	{Name: "frontend.ssl.key.path", Type: FilePath, Description: "Key of the default frontend certificate"},
+	{Name: "frontend.ssl.certs", Type: List, Description: "Additional <cert path>:<key path> frontend certificate pairs"},
+	{Name: "frontend.ssl.certs.dir", Type: FilePath, Description: "Directory of <name>.crt and <name>.key frontend certificate pairs"},
+	{Name: "frontend.ssl.bootstrap.dir", Type: String, Description: "Where bootstrapped certificates are kept"},
+	{Name: "frontend.ssl.bootstrap.sans", Type: List, Description: "Extra names for the bootstrapped serving certificate"},
+
+	{Name: "frontend.http.allow.cleartext", Type: Bool, Description: "Proxy requests over http instead of redirecting to https"},
+	{Name: "frontend.http.redirect.code", Type: Int, Check: oneOf("301", "308"), Description: "Status of http to https redirects"},
+	{Name: "frontend.http.redirect.host", Type: String, Description: "host[:port] http requests are redirected to"},
+
+	{Name: "proxy.protocol.trusted.cidrs", Type: CIDRList, Description: "Load balancers allowed to send PROXY protocol headers"},
+	{Name: "proxy.protocol.trusted.unix", Type: Bool, Description: "Allow unix socket peers to send PROXY protocol headers"},
+	{Name: "forwarded.trusted.cidrs", Type: CIDRList, Description: "Proxies whose forwarded.header is trusted"},
+	{Name: "forwarded.header", Type: String, Check: oneOf("x-forwarded-for", "forwarded"), Description: "Header trusted proxies record the client in: x-forwarded-for or forwarded"},
+
+	{Name: "ipfilter.allow", Type: CIDRList, Description: "Client addresses allowed to use the proxy"},
+	{Name: "ipfilter.deny", Type: CIDRList, Description: "Client addresses not allowed to use the proxy"},
+	{Name: "ipfilter.user.*.allow", Type: CIDRList, Description: "Client addresses a user is allowed to use the proxy from"},
+	{Name: "ipfilter.user.*.deny", Type: CIDRList, Description: "Client addresses a user isn't allowed to use the proxy from"},
+	{Name: "ipfilter.group.*.allow", Type: CIDRList, Description: "Client addresses members of a group are allowed to use the proxy from"},
+	{Name: "ipfilter.group.*.deny", Type: CIDRList, Description: "Client addresses members of a group aren't allowed to use the proxy from"},
+
+	{Name: "ratelimit.user.qps", Type: Float, Check: nonNegative, Description: "Requests per second allowed per user, 0 for no limit"},
+	{Name: "ratelimit.user.burst", Type: Int, Check: nonNegative, Description: "Burst of requests allowed per user"},
+	{Name: "ratelimit.group.qps", Type: Float, Check: nonNegative, Description: "Requests per second allowed per group, 0 for no limit"},
+	{Name: "ratelimit.group.burst", Type: Int, Check: nonNegative, Description: "Burst of requests allowed per group"},
+	{Name: "ratelimit.groups", Type: List, Description: "Groups that are rate limited"},
+	{Name: "maxinflight.readonly", Type: Int, Check: nonNegative, Description: "Read-only requests in flight, 0 for no limit"},
+	{Name: "maxinflight.mutating", Type: Int, Check: nonNegative, Description: "Mutating requests in flight, 0 for no limit"},
+	{Name: "maxinflight.longrunning", Type: Int, Check: nonNegative, Description: "Watch, exec, attach and log requests in flight, 0 for no limit"},
+
+	{Name: "lockout.user.threshold", Type: Int, Check: nonNegative, Description: "Failed authentications before a user is locked out"},
+	{Name: "lockout.ip.threshold", Type: Int, Check: nonNegative, Description: "Failed authentications before a client IP is locked out"},
+	{Name: "lockout.duration", Type: Duration, Description: "Length of the first lockout"},
+	{Name: "lockout.max.duration", Type: Duration, Description: "Longest lockout"},
+	{Name: "lockout.window", Type: Duration, Description: "How long failures are remembered"},
+	{Name: "lockout.max.records", Type: Int, Check: nonNegative, Description: "Usernames and client IPs tracked each, 0 for no limit"},
+
+	{Name: "accesslog.output", Type: String, Description: "Where to write the access log: stdout or a file path. Unset disables it"},
+	{Name: "accesslog.format", Type: String, Check: oneOf("common", "combined", "json"), Description: "Access log format: common, combined or json"},
+	{Name: "accesslog.sample.rate", Type: Float, Check: fraction, Description: "Fraction of successful requests to log"},
+	{Name: "accesslog.exclude.paths", Type: List, Description: "Paths not to log, exact or prefixes ending in *"},
+	{Name: "accesslog.max.size", Type: Int, Check: nonNegative, Description: "Size in MB at which the access log file is rotated, 0 to never rotate"},
+	{Name: "accesslog.max.backups", Type: Int, Check: nonNegative, Description: "Rotated access log files to keep"},
+
+	{Name: "admin.token", Type: String, Sensitive: true, Description: "Bearer token required by the admin API"},
+
+	{Name: "shutdown.delay", Type: Duration, Description: "Wait after failing readiness before closing listeners"},
+	{Name: "shutdown.grace.period", Type: Duration, Description: "Wait for in flight requests when shutting down"},
+}
+
+func init() {
+	for _, prefix := range []string{"frontend.http", "frontend.https", "admin.http"} {
+		keys = append(keys,
+			Key{Name: prefix + ".host", Type: String, Description: "Address to listen on: host:port, unix://<path>, fd://<n> or systemd://[name]"},
+			Key{Name: prefix + ".proxy.protocol", Type: Bool, Description: "Accept PROXY protocol headers"},
+			Key{Name: prefix + ".socket.mode", Type: String, Description: "Octal permissions of the unix socket"},
+			Key{Name: prefix + ".socket.owner", Type: String, Description: "Owner of the unix socket"},
+			Key{Name: prefix + ".socket.group", Type: String, Description: "Group of the unix socket"},
+		)
+	}
+
+	for _, prefix := range []string{"frontend.tls", "backend.tls"} {
+		keys = append(keys,
+			Key{Name: prefix + ".profile", Type: String, Check: oneOf("modern", "intermediate"), Description: "TLS profile"},
+			Key{Name: prefix + ".min.version", Type: String, Check: oneOf("1.0", "1.1", "1.2", "1.3"), Description: "Minimum TLS version"},
+			Key{Name: prefix + ".cipher.suites", Type: List, Description: "Cipher suites, by Go name"},
+			Key{Name: prefix + ".curves", Type: List, Check: eachOneOf("P256", "P384", "P521", "X25519"), Description: "Curve preferences"},
+			Key{Name: prefix + ".http2", Type: Bool, Description: "Offer HTTP/2"},
+		)
+	}
+}
+
+func checkLogLevel(v string) error {
+	_, err := logrus.ParseLevel(v)
+	return err
+}
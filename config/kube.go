@@ -0,0 +1,312 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/metrics"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// namespaceFile holds the namespace of the pod, which ConfigMaps and Secrets default to
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// watchTimeout is how long the apiserver keeps a watch open before it is renewed
+	watchTimeout = 5 * time.Minute
+)
+
+// retryInterval is the wait before listing again after a watch fails
+var retryInterval = 5 * time.Second
+
+// KubeClient reaches the Kubernetes API at Host, a base URL such as https://10.0.0.1:443, through Client.
+type KubeClient struct {
+	Host   string
+	Client *http.Client
+}
+
+// InClusterClient uses the service account credentials that pods are given.
+func InClusterClient() (*KubeClient, error) {
+	kubeConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := rest.TransportFor(kubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create transport for the Kubernetes API")
+	}
+	return &KubeClient{
+		Host:   kubeConfig.Host,
+		Client: &http.Client{Transport: transport},
+	}, nil
+}
+
+// kubeSource keeps a source in sync with a ConfigMap or Secret. Each key of the object's data is a config key,
+// except keys ending in .properties, .yaml, .yml or .json, which are config files whose keys are all added.
+type kubeSource struct {
+	mgr       *Manager
+	client    *KubeClient
+	resource  string
+	namespace string
+	name      string
+	retry     time.Duration
+	// resourceVersion is the version last seen, that the watch continues from
+	resourceVersion string
+}
+
+type kubeObject struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+type kubeEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// AddConfigMap adds the keys of a ConfigMap, given as <namespace>/<name> or <name> for one in the pod's namespace,
+// and follows changes to it. A ConfigMap that doesn't exist yet is added once it's created.
+func (m *Manager) AddConfigMap(client *KubeClient, ref string) error {
+	return m.addKubeSource(client, "configmaps", ref)
+}
+
+// AddSecret is AddConfigMap for a Secret, whose values are all treated as sensitive.
+func (m *Manager) AddSecret(client *KubeClient, ref string) error {
+	return m.addKubeSource(client, "secrets", ref)
+}
+
+func (m *Manager) addKubeSource(client *KubeClient, resource, ref string) error {
+	namespace, name := "", ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		namespace, name = ref[:i], ref[i+1:]
+	} else {
+		data, err := ioutil.ReadFile(namespaceFile)
+		if err != nil {
+			return errors.Wrapf(err, "no namespace given for %v and couldn't read the pod's", ref)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	if namespace == "" || name == "" {
+		return errors.Errorf("invalid %v %q, expected <namespace>/<name> or <name>", resource, ref)
+	}
+
+	s := &kubeSource{
+		mgr:       m,
+		client:    client,
+		resource:  resource,
+		namespace: namespace,
+		name:      name,
+		retry:     retryInterval,
+	}
+	if err := s.list(true); err != nil {
+		return err
+	}
+	go s.run()
+	return nil
+}
+
+// sourceName is the name used by SetPrecedence: configmap/<namespace>/<name> or secret/<namespace>/<name>.
+func (s *kubeSource) sourceName() string {
+	return fmt.Sprintf("%v/%v/%v", strings.TrimSuffix(s.resource, "s"), s.namespace, s.name)
+}
+
+func (s *kubeSource) run() {
+	for {
+		err := s.watch()
+		if s.mgr.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logrus.Warnf("Error watching %v, retrying: %v", s.sourceName(), err)
+			select {
+			case <-time.After(s.retry):
+			case <-s.mgr.ctx.Done():
+				return
+			}
+			// Events may have been missed, so start over from the current object
+			if err := s.list(false); err != nil {
+				logrus.Warnf("Error reading %v: %v", s.sourceName(), err)
+			}
+		}
+	}
+}
+
+// list reads the object and applies it. A missing object removes the source.
+func (s *kubeSource) list(initial bool) error {
+	resp, err := s.get(fmt.Sprintf("/api/v1/namespaces/%v/%v/%v", s.namespace, s.resource, s.name), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		logrus.Warnf("%v doesn't exist, it will be added once it's created", s.sourceName())
+		s.resourceVersion = ""
+		s.apply(nil, initial)
+		return nil
+	default:
+		return errors.Errorf("couldn't read %v: %v", s.sourceName(), resp.Status)
+	}
+
+	var obj kubeObject
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return errors.Wrapf(err, "couldn't decode %v", s.sourceName())
+	}
+	if !initial && obj.Metadata.ResourceVersion == s.resourceVersion {
+		return nil
+	}
+	s.resourceVersion = obj.Metadata.ResourceVersion
+	return s.apply(&obj, initial)
+}
+
+// watch follows changes to the object until the watch ends.
+func (s *kubeSource) watch() error {
+	query := url.Values{
+		"watch":          {"true"},
+		"fieldSelector":  {"metadata.name=" + s.name},
+		"timeoutSeconds": {fmt.Sprint(int(watchTimeout.Seconds()))},
+	}
+	if s.resourceVersion != "" {
+		query.Set("resourceVersion", s.resourceVersion)
+	}
+	resp, err := s.get(fmt.Sprintf("/api/v1/namespaces/%v/%v", s.namespace, s.resource), query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("couldn't watch %v: %v", s.sourceName(), resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event kubeEvent
+		if err := decoder.Decode(&event); err != nil {
+			if s.mgr.ctx.Err() != nil || err == io.EOF {
+				// The apiserver ended the watch after its timeout
+				return nil
+			}
+			return err
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			var obj kubeObject
+			if err := json.Unmarshal(event.Object, &obj); err != nil {
+				return errors.Wrapf(err, "couldn't decode %v", s.sourceName())
+			}
+			s.resourceVersion = obj.Metadata.ResourceVersion
+			s.apply(&obj, false)
+		case "DELETED":
+			logrus.Warnf("%v was deleted, dropping its values", s.sourceName())
+			s.resourceVersion = ""
+			s.apply(nil, false)
+		case "ERROR":
+			// Usually the resource version being too old to watch from
+			return errors.Errorf("watch error: %s", event.Object)
+		}
+	}
+}
+
+func (s *kubeSource) get(path string, query url.Values) (*http.Response, error) {
+	u := strings.TrimSuffix(s.client.Host, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Client.Do(req.WithContext(s.mgr.ctx))
+}
+
+// apply replaces the source's values with those of obj, or removes the source if obj is nil. If the data can't be
+// parsed the previous values are kept.
+func (s *kubeSource) apply(obj *kubeObject, initial bool) error {
+	secret := s.resource == "secrets"
+	var values map[string]string
+	var err error
+	if obj != nil {
+		values, err = s.parse(obj.Data, secret)
+	}
+	if !initial {
+		s.mgr.beginChange()
+		defer s.mgr.endChange()
+		if err != nil {
+			metrics.ConfigReloadFailures.WithLabelValues(s.sourceName()).Inc()
+			logrus.Errorf("Error parsing %v: %v", s.sourceName(), err)
+			return err
+		}
+		if obj != nil {
+			metrics.ConfigReloads.WithLabelValues(s.sourceName()).Inc()
+			logrus.Infof("Reloaded %v", s.sourceName())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.mgr.setSource(s.sourceName(), FilePriority, values, secret)
+	return nil
+}
+
+// parse reads the data keys as config keys, and those with a config file extension as config files. Files are merged
+// in order of their keys, so later ones win, and keys set directly win over files.
+func (s *kubeSource) parse(data map[string]string, secret bool) (map[string]string, error) {
+	names := make([]string, 0, len(data))
+	for k := range data {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	values := map[string]string{}
+	direct := map[string]string{}
+	for _, k := range names {
+		v := data[k]
+		if secret {
+			decoded, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldn't decode %v", k)
+			}
+			v = string(decoded)
+		}
+
+		var fileValues map[string]string
+		var err error
+		switch strings.ToLower(filepath.Ext(k)) {
+		case ".properties":
+			fileValues, err = parseProperties([]byte(v))
+		case ".yaml", ".yml":
+			fileValues, err = parseYAML([]byte(v))
+		case ".json":
+			fileValues, err = parseJSON([]byte(v))
+		default:
+			direct[k] = v
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %v", k)
+		}
+		for fk, fv := range fileValues {
+			values[fk] = fv
+		}
+	}
+	for k, v := range direct {
+		values[k] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,322 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPIServer serves a single ConfigMap or Secret the way the apiserver does: GET returns it, and a watch streams
+// the events the test sends until the test ends it.
+type fakeAPIServer struct {
+	t        *testing.T
+	server   *httptest.Server
+	resource string
+
+	m      sync.Mutex
+	object *kubeObject
+	gets   int
+
+	watches chan *fakeWatch
+}
+
+// fakeWatch is a watch request the server is handling. Events sent are streamed to the client, and closing events
+// ends the watch like the apiserver's timeout does.
+type fakeWatch struct {
+	resourceVersion string
+	fieldSelector   string
+	events          chan string
+}
+
+func newFakeAPIServer(t *testing.T, resource string) *fakeAPIServer {
+	s := &fakeAPIServer{
+		t:        t,
+		resource: resource,
+		watches:  make(chan *fakeWatch, 10),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("/api/v1/namespaces/ns/%v/config", resource), s.get)
+	mux.HandleFunc(fmt.Sprintf("/api/v1/namespaces/ns/%v", resource), s.watch)
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *fakeAPIServer) client() *KubeClient {
+	return &KubeClient{Host: s.server.URL, Client: s.server.Client()}
+}
+
+func (s *fakeAPIServer) set(obj *kubeObject) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.object = obj
+}
+
+func (s *fakeAPIServer) getCount() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.gets
+}
+
+func (s *fakeAPIServer) get(rw http.ResponseWriter, req *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.gets++
+	if s.object == nil {
+		http.Error(rw, `{"kind":"Status","code":404}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(rw).Encode(s.object)
+}
+
+func (s *fakeAPIServer) watch(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("watch") != "true" {
+		http.Error(rw, "expected a watch", http.StatusBadRequest)
+		return
+	}
+	w := &fakeWatch{
+		resourceVersion: query.Get("resourceVersion"),
+		fieldSelector:   query.Get("fieldSelector"),
+		events:          make(chan string),
+	}
+	s.watches <- w
+
+	rw.WriteHeader(http.StatusOK)
+	rw.(http.Flusher).Flush()
+	for {
+		select {
+		case event, ok := <-w.events:
+			if !ok {
+				return
+			}
+			fmt.Fprintln(rw, event)
+			rw.(http.Flusher).Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// nextWatch waits for the client to start a watch.
+func (s *fakeAPIServer) nextWatch() *fakeWatch {
+	select {
+	case w := <-s.watches:
+		return w
+	case <-time.After(waitTimeout):
+		s.t.Fatal("no watch started")
+		return nil
+	}
+}
+
+func (w *fakeWatch) send(t *testing.T, eventType string, obj interface{}) {
+	data, err := json.Marshal(map[string]interface{}{"type": eventType, "object": obj})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case w.events <- string(data):
+	case <-time.After(waitTimeout):
+		t.Fatalf("client isn't reading the watch")
+	}
+}
+
+func newKubeObject(resourceVersion string, data map[string]string) *kubeObject {
+	obj := &kubeObject{Data: data}
+	obj.Metadata.ResourceVersion = resourceVersion
+	return obj
+}
+
+func newKubeTestManager() (*Manager, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return newManager(ctx), cancel
+}
+
+func TestConfigMapWatchEvents(t *testing.T) {
+	api := newFakeAPIServer(t, "configmaps")
+	defer api.server.Close()
+	m, cancel := newKubeTestManager()
+	defer cancel()
+
+	api.set(newKubeObject("1", map[string]string{
+		"backend.host":      "a:6443",
+		"server.properties": "log.level=debug\n",
+	}))
+	if err := m.AddConfigMap(api.client(), "ns/config"); err != nil {
+		t.Fatal(err)
+	}
+	// The initial list is applied before AddConfigMap returns
+	if m.Get("backend.host") != "a:6443" || m.Get("log.level") != "debug" {
+		t.Fatalf("initial values not loaded: %v", m.Entries())
+	}
+
+	w := api.nextWatch()
+	if w.resourceVersion != "1" || w.fieldSelector != "metadata.name=config" {
+		t.Fatalf("watch started with resourceVersion %q and fieldSelector %q", w.resourceVersion, w.fieldSelector)
+	}
+	w.send(t, "MODIFIED", newKubeObject("2", map[string]string{"backend.host": "b:6443"}))
+	waitFor(t, m, "backend.host", "b:6443")
+	waitFor(t, m, "log.level", "")
+	w.send(t, "ADDED", newKubeObject("3", map[string]string{"backend.host": "c:6443"}))
+	waitFor(t, m, "backend.host", "c:6443")
+
+	// When the watch times out, the next one resumes from the last version seen
+	close(w.events)
+	w = api.nextWatch()
+	if w.resourceVersion != "3" {
+		t.Fatalf("watch resumed from resourceVersion %q, expected 3", w.resourceVersion)
+	}
+
+	w.send(t, "DELETED", newKubeObject("4", nil))
+	waitFor(t, m, "backend.host", "")
+	for _, e := range m.Entries() {
+		if e.Source == "configmap/ns/config" {
+			t.Fatalf("deleted ConfigMap still sets %v", e.Key)
+		}
+	}
+
+	// After a delete the watch starts over, so the ConfigMap is picked up when it's created again
+	close(w.events)
+	w = api.nextWatch()
+	if w.resourceVersion != "" {
+		t.Fatalf("watch after delete resumed from resourceVersion %q", w.resourceVersion)
+	}
+	w.send(t, "ADDED", newKubeObject("5", map[string]string{"backend.host": "d:6443"}))
+	waitFor(t, m, "backend.host", "d:6443")
+}
+
+func TestConfigMapCreatedLater(t *testing.T) {
+	api := newFakeAPIServer(t, "configmaps")
+	defer api.server.Close()
+	m, cancel := newKubeTestManager()
+	defer cancel()
+
+	if err := m.AddConfigMap(api.client(), "ns/config"); err != nil {
+		t.Fatalf("a missing ConfigMap should be waited for: %v", err)
+	}
+	if m.Get("backend.host") != "" {
+		t.Fatal("missing ConfigMap set values")
+	}
+
+	w := api.nextWatch()
+	if w.resourceVersion != "" {
+		t.Fatalf("watch of missing ConfigMap started from resourceVersion %q", w.resourceVersion)
+	}
+	w.send(t, "ADDED", newKubeObject("7", map[string]string{"backend.host": "a:6443"}))
+	waitFor(t, m, "backend.host", "a:6443")
+}
+
+func TestConfigMapRelistAfterWatchError(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	api := newFakeAPIServer(t, "configmaps")
+	defer api.server.Close()
+	m, cancel := newKubeTestManager()
+	defer cancel()
+
+	api.set(newKubeObject("1", map[string]string{"backend.host": "a:6443"}))
+	if err := m.AddConfigMap(api.client(), "ns/config"); err != nil {
+		t.Fatal(err)
+	}
+	w := api.nextWatch()
+
+	// Changes made while the watch can't continue are found by listing again
+	api.set(newKubeObject("9", map[string]string{"backend.host": "b:6443"}))
+	w.send(t, "ERROR", map[string]interface{}{
+		"kind":    "Status",
+		"status":  "Failure",
+		"reason":  "Gone",
+		"code":    http.StatusGone,
+		"message": "too old resource version: 1 (5)",
+	})
+	waitFor(t, m, "backend.host", "b:6443")
+	if gets := api.getCount(); gets != 2 {
+		t.Fatalf("expected the initial GET and one relist, got %v GETs", gets)
+	}
+
+	w = api.nextWatch()
+	if w.resourceVersion != "9" {
+		t.Fatalf("watch after relist started from resourceVersion %q, expected 9", w.resourceVersion)
+	}
+}
+
+func TestSecretValuesAreDecodedAndSensitive(t *testing.T) {
+	api := newFakeAPIServer(t, "secrets")
+	defer api.server.Close()
+	m, cancel := newKubeTestManager()
+	defer cancel()
+
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	api.set(newKubeObject("1", map[string]string{
+		"admin.token":  encode("s3cret"),
+		"extra.yaml":   encode("ratelimit:\n  user:\n    qps: 5\n"),
+		"backend.host": encode("a:6443"),
+	}))
+	if err := m.AddSecret(api.client(), "ns/config"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Get("admin.token") != "s3cret" || m.Get("ratelimit.user.qps") != "5" {
+		t.Fatalf("secret values not decoded: %v", m.Entries())
+	}
+	for _, key := range []string{"admin.token", "ratelimit.user.qps", "backend.host"} {
+		if !m.IsSensitive(key) {
+			t.Errorf("%v from a Secret isn't sensitive", key)
+		}
+	}
+
+	// Data that isn't valid base64 is rejected and the previous values kept
+	w := api.nextWatch()
+	w.send(t, "MODIFIED", newKubeObject("2", map[string]string{"backend.host": "not base64!"}))
+	time.Sleep(100 * time.Millisecond)
+	if m.Get("backend.host") != "a:6443" {
+		t.Fatalf("backend.host is %q after an invalid update", m.Get("backend.host"))
+	}
+	w.send(t, "MODIFIED", newKubeObject("3", map[string]string{"backend.host": encode("b:6443"), "admin.token": encode("s3cret")}))
+	waitFor(t, m, "backend.host", "b:6443")
+	if m.Get("admin.token") != "s3cret" {
+		t.Fatalf("admin.token is %q after update", m.Get("admin.token"))
+	}
+}
+
+func TestSecretWithInvalidDataIsRejected(t *testing.T) {
+	api := newFakeAPIServer(t, "secrets")
+	defer api.server.Close()
+	m, cancel := newKubeTestManager()
+	defer cancel()
+
+	api.set(newKubeObject("1", map[string]string{"admin.token": "not base64!"}))
+	if err := m.AddSecret(api.client(), "ns/config"); err == nil {
+		t.Fatal("expected an error for data that isn't base64")
+	}
+}
+
+func TestEmbeddedFilesMergeInKeyOrder(t *testing.T) {
+	data := map[string]string{
+		"a.properties":  "backend.host=a:6443\nlog.level=debug\n",
+		"b.yaml":        "backend:\n  host: b:6443\n",
+		"c.json":        `{"backend": {"scheme": "https"}}`,
+		"log.level":     "warning",
+		"z.properties":  "log.level=error\nbackend.scheme=http\n",
+		"00.properties": "backend.host=first:6443\n",
+	}
+	want := map[string]string{"backend.host": "b:6443", "backend.scheme": "http", "log.level": "warning"}
+	s := &kubeSource{}
+	// Map iteration order varies, so parse enough times for a wrong merge order to show
+	for i := 0; i < 50; i++ {
+		values, err := s.parse(data, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range want {
+			if values[k] != v {
+				t.Fatalf("%v = %q, want %q", k, values[k], v)
+			}
+		}
+	}
+}
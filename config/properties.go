@@ -0,0 +1,118 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseProperties parses the format of java.util.Properties:
+// - # and ! start comment lines
+// - keys are separated from values by =, : or whitespace, with whitespace around the separator ignored
+// - a line ending in an odd number of backslashes continues on the next, whose leading whitespace is skipped
+// - \t, \n, \r, \f and \uXXXX are escapes, and a backslash before any other character is dropped
+// Spaces, separators and comment characters can be escaped to use them in keys. As in Java, trailing whitespace is
+// part of the value.
+func parseProperties(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	lines := strings.Split(strings.Replace(strings.Replace(string(data), "\r\n", "\n", -1), "\r", "\n", -1), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := strings.TrimLeft(lines[i], propertiesSpace)
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+
+		// Join continuation lines
+		for continues(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], propertiesSpace)
+		}
+		if continues(line) {
+			// A continuation at the end of the file continues into nothing
+			line = line[:len(line)-1]
+		}
+
+		keyEnd := len(line)
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+				continue
+			}
+			if strings.IndexByte("=:"+propertiesSpace, line[j]) >= 0 {
+				keyEnd = j
+				break
+			}
+		}
+
+		rest := strings.TrimLeft(line[keyEnd:], propertiesSpace)
+		if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+			rest = strings.TrimLeft(rest[1:], propertiesSpace)
+		}
+
+		key, err := unescapeProperty(line[:keyEnd])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+		value, err := unescapeProperty(rest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+const propertiesSpace = " \t\f"
+
+// continues reports whether line ends in an odd number of backslashes.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var result []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			if s[i] != '\\' {
+				result = append(result, s[i])
+			}
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			result = append(result, '\t')
+		case 'n':
+			result = append(result, '\n')
+		case 'r':
+			result = append(result, '\r')
+		case 'f':
+			result = append(result, '\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", errors.Errorf(`malformed \u escape in %q`, s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", errors.Errorf(`malformed \u escape in %q`, s)
+			}
+			result = append(result, string(rune(r))...)
+			i += 4
+		default:
+			result = append(result, s[i])
+		}
+	}
+	return string(result), nil
+}
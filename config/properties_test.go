@@ -0,0 +1,123 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProperties(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "separators",
+			input: "a=1\nb:2\nc 3\nd = 4\ne : 5\nf\t\t6\ng :=7\nh=:8\n",
+			want:  map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6", "g": "=7", "h": ":8"},
+		},
+		{
+			name:  "key without value",
+			input: "empty\nempty.sep=\n",
+			want:  map[string]string{"empty": "", "empty.sep": ""},
+		},
+		{
+			name:  "comments and blank lines",
+			input: "# comment\n! also a comment\n   # indented comment\n\n \t \na=1 # not a comment\n",
+			want:  map[string]string{"a": "1 # not a comment"},
+		},
+		{
+			name:  "leading whitespace",
+			input: "   a=1\n\tb = 2\n",
+			want:  map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:  "trailing whitespace is kept",
+			input: "a=1  \nb=2\\ \n",
+			want:  map[string]string{"a": "1  ", "b": "2 "},
+		},
+		{
+			name:  "continuation lines",
+			input: "a=one, \\\n    two, \\\n\tthree\nb=x\n",
+			want:  map[string]string{"a": "one, two, three", "b": "x"},
+		},
+		{
+			name:  "continuation line starting like a comment",
+			input: "a=one \\\n  # two\n",
+			want:  map[string]string{"a": "one # two"},
+		},
+		{
+			name:  "continuation at the end of the file",
+			input: "a=one\\",
+			want:  map[string]string{"a": "one"},
+		},
+		{
+			name:  "escaped backslash doesn't continue",
+			input: "a=c:\\\\\nb=2\n",
+			want:  map[string]string{"a": `c:\`, "b": "2"},
+		},
+		{
+			name:  "continued key",
+			input: "long\\\n  key=1\n",
+			want:  map[string]string{"longkey": "1"},
+		},
+		{
+			name:  "escaped separators in keys",
+			input: "a\\=b=1\nc\\:d:2\ne\\ f 3\n\\#g=4\n\\!h=5\n",
+			want:  map[string]string{"a=b": "1", "c:d": "2", "e f": "3", "#g": "4", "!h": "5"},
+		},
+		{
+			name:  "escapes",
+			input: "a=tab\\there\nb=line\\nbreak\nc=\\r\\f\nd=\\q\\\\\n",
+			want:  map[string]string{"a": "tab\there", "b": "line\nbreak", "c": "\r\f", "d": `q\`},
+		},
+		{
+			name:  "unicode escapes",
+			input: "a=\\u00e9t\\u00E9\nb\\u003dc=\\u2603\n",
+			want:  map[string]string{"a": "été", "b=c": "☃"},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "a=1\r\nb=one \\\r\n  two\r\n",
+			want:  map[string]string{"a": "1", "b": "one two"},
+		},
+		{
+			name:  "CR line endings",
+			input: "a=1\rb=2\r",
+			want:  map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:  "later keys win",
+			input: "a=1\na=2\n",
+			want:  map[string]string{"a": "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := parseProperties([]byte(tt.input))
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePropertiesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "short unicode escape", input: "a=\\u12"},
+		{name: "invalid unicode escape", input: "a=\\u12zz\n"},
+		{name: "invalid unicode escape in key", input: "\\uxyz1=a\n"},
+	}
+
+	for _, tt := range tests {
+		if got, err := parseProperties([]byte(tt.input)); err == nil {
+			t.Errorf("%v: expected an error, got %q", tt.name, got)
+		}
+	}
+}
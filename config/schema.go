@@ -0,0 +1,301 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// ValueType is how a config value is parsed.
+type ValueType int
+
+const (
+	String ValueType = iota
+	Bool
+	Int
+	Float
+	Duration
+	// List is comma separated, with surrounding whitespace and empty entries ignored
+	List
+	URL
+	// FilePath must name an existing, readable file or directory
+	FilePath
+	// CIDRList is a List of CIDRs or single IPs
+	CIDRList
+)
+
+var typeNames = map[ValueType]string{
+	String:   "string",
+	Bool:     "bool",
+	Int:      "int",
+	Float:    "float",
+	Duration: "duration",
+	List:     "list",
+	URL:      "url",
+	FilePath: "file path",
+	CIDRList: "CIDR list",
+}
+
+func (t ValueType) String() string {
+	return typeNames[t]
+}
+
+// Key describes a known config key.
+type Key struct {
+	// Name may contain one *, standing for any non-empty part such as a user or group name in
+	// ipfilter.user.*.allow
+	Name        string
+	Type        ValueType
+	Description string
+	// Check, if set, further validates values that parse as Type
+	Check func(string) error
+	// Sensitive values are redacted from logs and output
+	Sensitive bool
+}
+
+func (k Key) matches(name string) bool {
+	i := strings.Index(k.Name, "*")
+	if i < 0 {
+		return k.Name == name
+	}
+	prefix, suffix := k.Name[:i], k.Name[i+1:]
+	return len(name) > len(prefix)+len(suffix) && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix)
+}
+
+func (k Key) validate(v string) error {
+	if err := checkType(k.Type, v); err != nil {
+		return err
+	}
+	if k.Check != nil {
+		return k.Check(v)
+	}
+	return nil
+}
+
+// Keys returns the known keys, sorted by name.
+func Keys() []Key {
+	result := append([]Key(nil), keys...)
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// LookupKey returns the known key that name matches.
+func LookupKey(name string) (Key, bool) {
+	for _, k := range keys {
+		if k.matches(name) {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// UnknownKeys describes every loaded key that isn't known, naming the closest known key since they're most likely
+// typos.
+func (m *Manager) UnknownKeys() []string {
+	var result []string
+	for _, e := range m.Entries() {
+		if _, ok := LookupKey(e.Key); ok {
+			continue
+		}
+		if suggestion := closestKey(e.Key); suggestion != "" {
+			result = append(result, fmt.Sprintf("Unknown config key %v in %v, did you mean %v?", e.Key, e.Source, suggestion))
+		} else {
+			result = append(result, fmt.Sprintf("Unknown config key %v in %v", e.Key, e.Source))
+		}
+	}
+	return result
+}
+
+// Validate checks every loaded value against the known keys, returning all invalid values and references that
+// couldn't be resolved together. Unknown keys are skipped, see UnknownKeys.
+func (m *Manager) Validate() error {
+	var errs []error
+	for _, e := range m.Entries() {
+		k, ok := LookupKey(e.Key)
+		if !ok {
+			continue
+		}
+		if e.Value == "" {
+			continue
+		}
+		if err := k.validate(e.Value); err != nil {
+			if e.Sensitive {
+				// The reason may include the value
+				errs = append(errs, errors.Errorf("invalid value for %v in %v", e.Key, e.Source))
+			} else {
+				errs = append(errs, errors.Wrapf(err, "invalid value for %v in %v", e.Key, e.Source))
+			}
+		}
+	}
+	errs = append(errs, m.referenceErrors()...)
+	return utilerrors.NewAggregate(errs)
+}
+
+func checkType(t ValueType, v string) error {
+	var err error
+	v = strings.TrimSpace(v)
+	switch t {
+	case Bool:
+		_, err = parseBool(v)
+	case Int:
+		_, err = strconv.Atoi(v)
+	case Float:
+		_, err = strconv.ParseFloat(v, 64)
+	case Duration:
+		_, err = time.ParseDuration(v)
+	case URL:
+		_, err = parseURL(v)
+	case FilePath:
+		err = checkReadable(v)
+	case CIDRList:
+		for _, s := range splitList(v) {
+			if err = checkCIDR(s); err != nil {
+				break
+			}
+		}
+	}
+	return err
+}
+
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, errors.Errorf("%q isn't true or false", v)
+}
+
+func parseURL(v string) (*url.URL, error) {
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf("%q isn't an absolute URL", v)
+	}
+	return u, nil
+}
+
+func checkCIDR(s string) error {
+	if strings.Contains(s, "/") {
+		_, _, err := net.ParseCIDR(s)
+		return err
+	}
+	if net.ParseIP(s) == nil {
+		return errors.Errorf("invalid IP or CIDR %q", s)
+	}
+	return nil
+}
+
+func splitList(v string) []string {
+	var result []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// closestKey suggests the known key within a couple of edits of name, if there is one.
+func closestKey(name string) string {
+	best, bestDistance := "", 3
+	for _, k := range keys {
+		if strings.Contains(k.Name, "*") {
+			continue
+		}
+		if d := editDistance(name, k.Name); d < bestDistance {
+			best, bestDistance = k.Name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
+
+// oneOf is a Key Check allowing only the given values.
+func oneOf(allowed ...string) func(string) error {
+	return func(v string) error {
+		for _, a := range allowed {
+			if v == a {
+				return nil
+			}
+		}
+		return errors.Errorf("%q isn't one of %v", v, strings.Join(allowed, ", "))
+	}
+}
+
+// eachOneOf is a Key Check allowing only lists of the given values.
+func eachOneOf(allowed ...string) func(string) error {
+	check := oneOf(allowed...)
+	return func(v string) error {
+		for _, s := range splitList(v) {
+			if err := check(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// nonNegative is a Key Check for Int and Float values.
+func nonNegative(v string) error {
+	if f, err := strconv.ParseFloat(v, 64); err == nil && f < 0 {
+		return errors.New("must not be negative")
+	}
+	return nil
+}
+
+// fraction is a Key Check for Float values between 0 and 1.
+func fraction(v string) error {
+	if f, err := strconv.ParseFloat(v, 64); err == nil && (f < 0 || f > 1) {
+		return errors.New("must be between 0 and 1")
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Values of the form file://<path> or env://<name> are references, replaced by the contents of the file or the
+// value of the environment variable, so that secrets can be kept out of config files. Referenced files are watched
+// and their values reloaded like config files. Values set by reference are treated as sensitive.
+const (
+	fileReference = "file://"
+	envReference  = "env://"
+
+	// Redacted replaces sensitive values in logs and output
+	Redacted = "<redacted>"
+)
+
+// secretFile is the last contents read from a referenced file.
+type secretFile struct {
+	value string
+	err   error
+}
+
+func isReference(v string) bool {
+	return strings.HasPrefix(v, fileReference) || strings.HasPrefix(v, envReference)
+}
+
+// resolve returns the value v refers to, or v if it isn't a reference. It must be called with m.m locked.
+func (m *Manager) resolve(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, envReference):
+		name := strings.TrimPrefix(v, envReference)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.Errorf("environment variable %v isn't set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(v, fileReference):
+		path := strings.TrimPrefix(v, fileReference)
+		f, ok := m.secretFiles[path]
+		if !ok {
+			f = &secretFile{}
+			f.value, f.err = readSecret(path)
+			m.secretFiles[path] = f
+			if err := m.WatchFile(path, func() { m.reloadSecret(path) }); err != nil {
+				logrus.Warnf("Couldn't watch secret file %v: %v", path, err)
+			}
+		}
+		return f.value, f.err
+	}
+	return v, nil
+}
+
+// reloadSecret rereads a referenced file. If it can no longer be read, its last value is kept.
+func (m *Manager) reloadSecret(path string) {
+	value, err := readSecret(path)
+
+	m.beginChange()
+	defer m.endChange()
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	f := m.secretFiles[path]
+	if err != nil && f.err == nil {
+		logrus.Warnf("Couldn't read secret file %v, keeping its last value: %v", path, err)
+		return
+	}
+	f.value, f.err = value, err
+	m.recompute()
+	if err == nil {
+		logrus.Infof("Reloaded secret file %v", path)
+	}
+}
+
+// readSecret reads a referenced file, dropping trailing newlines that editors and echo add.
+func readSecret(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// IsSensitive reports whether the value of key is a secret, because the key is marked Sensitive or because its
+// value was set by reference or from a sensitive source such as a Kubernetes Secret.
+func (m *Manager) IsSensitive(key string) bool {
+	if k, ok := LookupKey(key); ok && k.Sensitive {
+		return true
+	}
+	m.m.RLock()
+	defer m.m.RUnlock()
+	return m.sensitive[key]
+}
+
+// Redact returns value, or Redacted if key is sensitive.
+func (m *Manager) Redact(key, value string) string {
+	if value != "" && m.IsSensitive(key) {
+		return Redacted
+	}
+	return value
+}
+
+// referenceErrors lists the references that couldn't be resolved, sorted by key.
+func (m *Manager) referenceErrors() []error {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	keys := make([]string, 0, len(m.unresolved))
+	for k := range m.unresolved {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, k := range keys {
+		errs = append(errs, m.unresolved[k])
+	}
+	return errs
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Priority orders kinds of sources: values from flags override those from the environment, which override those
+// from files.
+type Priority int
+
+const (
+	FilePriority Priority = iota
+	EnvPriority
+	FlagPriority
+)
+
+// source is a set of values from one place, such as a config file.
+type source struct {
+	name     string
+	priority Priority
+	values   map[string]string
+	// sensitive sources, such as Secrets, have all their values redacted
+	sensitive bool
+	// seq orders sources by when they were first added
+	seq int
+}
+
+// Entry is an effective config value and the source it came from.
+type Entry struct {
+	Key    string
+	Value  string
+	Source string
+	// Reference is the file:// or env:// reference the value was read from, if any
+	Reference string
+	Sensitive bool
+}
+
+// SetPrecedence orders sources of the same Priority, named by path for files, from highest precedence to lowest.
+// Sources that aren't listed rank below those that are, with sources added later taking precedence over those added
+// earlier.
+func (m *Manager) SetPrecedence(sources ...string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.precedence = append([]string(nil), sources...)
+	m.recompute()
+}
+
+// GetWithSource returns the value of key and the source it came from, which is empty if the key isn't set.
+func (m *Manager) GetWithSource(key string) (string, string) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	return m.config[key], m.origin[key]
+}
+
+// Sources lists the sources that set at least one value, sorted by name.
+func (m *Manager) Sources() []string {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	var result []string
+	for name, s := range m.sources {
+		if len(s.values) > 0 {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Entries lists every effective value, sorted by key.
+func (m *Manager) Entries() []Entry {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	result := make([]Entry, 0, len(m.config))
+	for k, v := range m.config {
+		key, known := LookupKey(k)
+		result = append(result, Entry{
+			Key:       k,
+			Value:     v,
+			Source:    m.origin[k],
+			Reference: m.references[k],
+			Sensitive: m.sensitive[k] || known && key.Sensitive,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// SetSource replaces the values of a source, so that keys no longer in it are removed, and recomputes the
+// effective config. A nil values removes the source.
+func (m *Manager) SetSource(name string, priority Priority, values map[string]string) {
+	m.setSource(name, priority, values, false)
+}
+
+func (m *Manager) setSource(name string, priority Priority, values map[string]string, sensitive bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if values == nil {
+		delete(m.sources, name)
+	} else if s, ok := m.sources[name]; ok {
+		s.values = values
+		s.priority = priority
+		s.sensitive = sensitive
+	} else {
+		m.seq++
+		m.sources[name] = &source{
+			name:      name,
+			priority:  priority,
+			values:    values,
+			sensitive: sensitive,
+			seq:       m.seq,
+		}
+	}
+	m.recompute()
+}
+
+// recompute rebuilds the effective config by applying sources from lowest precedence to highest, then resolves
+// references. It must be called with m.m locked.
+func (m *Manager) recompute() {
+	rank := map[string]int{}
+	for i, name := range m.precedence {
+		if _, ok := rank[name]; !ok {
+			rank[name] = i
+		}
+	}
+
+	ordered := make([]*source, 0, len(m.sources))
+	for _, s := range m.sources {
+		ordered = append(ordered, s)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].priority != ordered[j].priority {
+			return ordered[i].priority < ordered[j].priority
+		}
+		ri, iRanked := rank[ordered[i].name]
+		rj, jRanked := rank[ordered[j].name]
+		switch {
+		case iRanked && jRanked:
+			return ri > rj
+		case iRanked != jRanked:
+			return jRanked
+		default:
+			return ordered[i].seq < ordered[j].seq
+		}
+	})
+
+	config := map[string]string{}
+	origin := map[string]string{}
+	sensitive := map[string]bool{}
+	for _, s := range ordered {
+		for k, v := range s.values {
+			config[k] = v
+			origin[k] = s.name
+			sensitive[k] = s.sensitive
+		}
+	}
+
+	references := map[string]string{}
+	unresolved := map[string]error{}
+	for k, v := range config {
+		if !isReference(v) {
+			continue
+		}
+		references[k] = v
+		sensitive[k] = true
+		resolved, err := m.resolve(v)
+		if err != nil {
+			// Leave the key unset rather than use the reference as the value
+			unresolved[k] = errors.Wrapf(err, "couldn't resolve %v for %v in %v", v, k, origin[k])
+			delete(config, k)
+			continue
+		}
+		config[k] = resolved
+	}
+
+	m.config, m.origin = config, origin
+	m.sensitive, m.references, m.unresolved = sensitive, references, unresolved
+}
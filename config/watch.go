@@ -0,0 +1,133 @@
+package config
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reloads often come as several file events in quick succession, such as an editor truncating and then writing,
+// or several files of a volume being updated. Subscribers are notified once things settle.
+const debounceInterval = 250 * time.Millisecond
+
+// Change is a key whose value was changed by a reload. Added keys have an empty Old value, and removed keys an
+// empty New value.
+type Change struct {
+	Key string
+	Old string
+	New string
+}
+
+type subscription struct {
+	keys     map[string]bool
+	onChange func([]Change)
+}
+
+type notifier struct {
+	// dispatch serializes notifications
+	dispatch sync.Mutex
+
+	m       sync.Mutex
+	subs    []*subscription
+	timer   *time.Timer
+	before  map[string]string
+	pending bool
+}
+
+// Watch calls onChange after config files are reloaded with the keys among keys whose values changed, or with all
+// changed keys if none are given. Changes are debounced, and calls to subscribers are serialized so they don't need
+// to worry about concurrent notifications.
+func (m *Manager) Watch(onChange func([]Change), keys ...string) {
+	sub := &subscription{
+		onChange: onChange,
+	}
+	if len(keys) > 0 {
+		sub.keys = map[string]bool{}
+		for _, k := range keys {
+			sub.keys[k] = true
+		}
+	}
+
+	m.notifier.m.Lock()
+	defer m.notifier.m.Unlock()
+	m.notifier.subs = append(m.notifier.subs, sub)
+}
+
+// beginChange records the values before a reload, unless a notification is already pending in which case the
+// values before that are kept.
+func (m *Manager) beginChange() {
+	n := &m.notifier
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.pending {
+		return
+	}
+	n.pending = true
+	n.before = m.snapshot()
+}
+
+// endChange schedules a notification once reloads have stopped for debounceInterval.
+func (m *Manager) endChange() {
+	n := &m.notifier
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.timer == nil {
+		n.timer = time.AfterFunc(debounceInterval, m.notify)
+	} else {
+		n.timer.Reset(debounceInterval)
+	}
+}
+
+func (m *Manager) notify() {
+	n := &m.notifier
+	n.dispatch.Lock()
+	defer n.dispatch.Unlock()
+
+	n.m.Lock()
+	before, after := n.before, m.snapshot()
+	n.before, n.pending = nil, false
+	subs := append([]*subscription(nil), n.subs...)
+	n.m.Unlock()
+
+	changes := diff(before, after)
+	if len(changes) == 0 {
+		return
+	}
+	for _, sub := range subs {
+		var matched []Change
+		for _, c := range changes {
+			if sub.keys == nil || sub.keys[c.Key] {
+				matched = append(matched, c)
+			}
+		}
+		if len(matched) > 0 {
+			sub.onChange(matched)
+		}
+	}
+}
+
+func (m *Manager) snapshot() map[string]string {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	result := make(map[string]string, len(m.config))
+	for k, v := range m.config {
+		result[k] = v
+	}
+	return result
+}
+
+func diff(before, after map[string]string) []Change {
+	var changes []Change
+	for k, v := range after {
+		if before[k] != v {
+			changes = append(changes, Change{Key: k, Old: before[k], New: v})
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, Change{Key: k, Old: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// Files are rechecked this often even without events, in case an event was missed or the directory to watch
+// didn't exist yet.
+var resyncInterval = time.Minute
+
+// watcher follows a file or directory. Rather than the file itself, it watches the directory containing it, since
+// files are often replaced rather than written:
+// - kubernetes ConfigMap and Secret volumes swap a ..data symlink that the files link through
+// - editors and tools like cert-manager write a new file and rename it over the old one
+// In both cases a watch on the file itself sees no event, or is left on the old file.
+// Any event in the directory, as well as a periodic resync, makes the watcher compare the contents with what it
+// last saw, so only real changes are reloaded.
+type watcher struct {
+	ctx       context.Context
+	path      string
+	fsNotify  *fsnotify.Watcher
+	parseType ParseType
+	mgr       *Manager
+	onChange  func()
+
+	// state is the contents last seen, or for directories a summary of the files in them
+	state   []byte
+	exists  bool
+	checked bool
+	loaded  bool
+}
+
+func (w *watcher) start() error {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrapf(err, "couldn't create watcher for file %v", w.path)
+	}
+	w.fsNotify = fsWatcher
+	w.addWatches()
+	go w.run(time.NewTicker(resyncInterval))
+	return nil
+}
+
+// addWatches watches the directory the file is in, or for directories the directory itself. The file is watched as
+// well, which catches writes to files that are symlinks to other directories. Paths that don't exist yet are left
+// to the resync.
+func (w *watcher) addWatches() {
+	dir := filepath.Dir(w.path)
+	if fi, err := os.Stat(w.path); err == nil && fi.IsDir() {
+		dir = w.path
+	}
+	if err := w.fsNotify.Add(dir); err != nil {
+		logrus.Debugf("Couldn't watch %v, relying on resync: %v", dir, err)
+	}
+	if dir != w.path {
+		w.fsNotify.Add(w.path)
+	}
+}
+
+func (w *watcher) run(resync *time.Ticker) {
+	defer resync.Stop()
+
+	for {
+		select {
+		case event := <-w.fsNotify.Events:
+			if event.Op == fsnotify.Chmod && event.Name == w.path {
+				continue
+			}
+			w.check()
+		case err := <-w.fsNotify.Errors:
+			logrus.Warnf("Error watching %v: %v", w.path, err)
+		case <-resync.C:
+			// Watches on files that were replaced, or on directories that didn't exist, need renewing
+			w.addWatches()
+			w.check()
+		case <-w.ctx.Done():
+			w.fsNotify.Close()
+			return
+		}
+	}
+}
+
+// check reloads the file, or notifies the consumer, if it changed since it was last seen.
+func (w *watcher) check() error {
+	state, exists := w.read()
+	if w.checked && exists == w.exists && bytes.Equal(state, w.state) {
+		return nil
+	}
+	initial := !w.checked
+	w.state, w.exists, w.checked = state, exists, true
+
+	if w.onChange != nil {
+		w.onChange()
+		return nil
+	}
+
+	if !exists {
+		err := &os.PathError{Op: "open", Path: w.path, Err: os.ErrNotExist}
+		w.mgr.setStatus(w.path, w.parseType, err)
+		if w.loaded {
+			logrus.Warnf("Config file %v was removed, dropping its values", w.path)
+			w.mgr.beginChange()
+			w.mgr.SetSource(w.path, FilePriority, nil)
+			w.mgr.endChange()
+			w.loaded = false
+		}
+		return err
+	}
+
+	if initial {
+		err := w.parse(state)
+		w.mgr.setStatus(w.path, w.parseType, err)
+		w.loaded = err == nil
+		return err
+	}
+
+	w.mgr.beginChange()
+	defer w.mgr.endChange()
+	err := w.parse(state)
+	w.mgr.setStatus(w.path, w.parseType, err)
+	if err != nil {
+		metrics.ConfigReloadFailures.WithLabelValues(w.path).Inc()
+		logrus.Errorf("Error parsing config file %v: %v", w.path, err)
+	} else {
+		metrics.ConfigReloads.WithLabelValues(w.path).Inc()
+		logrus.Infof("Reloaded config file %v", w.path)
+		w.loaded = true
+	}
+	return err
+}
+
+// read returns the current state of the file and whether it exists. Files that exist but can't be read are
+// treated as missing until they can.
+func (w *watcher) read() ([]byte, bool) {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return nil, false
+	}
+	if !fi.IsDir() {
+		data, err := ioutil.ReadFile(w.path)
+		if err != nil {
+			logrus.Warnf("Couldn't read %v: %v", w.path, err)
+			return nil, false
+		}
+		return data, true
+	}
+
+	files, err := ioutil.ReadDir(w.path)
+	if err != nil {
+		logrus.Warnf("Couldn't read %v: %v", w.path, err)
+		return nil, false
+	}
+	var summary bytes.Buffer
+	for _, f := range files {
+		// Follow symlinks, so that swapping what they point to counts as a change
+		if target, err := os.Stat(filepath.Join(w.path, f.Name())); err == nil {
+			f = target
+		}
+		fmt.Fprintf(&summary, "%s %d %d\n", f.Name(), f.Size(), f.ModTime().UnixNano())
+	}
+	return summary.Bytes(), true
+}
+
+func (w *watcher) parse(data []byte) error {
+	var values map[string]string
+	var err error
+	switch w.parseType {
+	case SingleValueFile:
+		values = map[string]string{
+			path.Base(w.path): string(data),
+		}
+	case YAMLFile:
+		values, err = parseYAML(data)
+	case JSONFile:
+		values, err = parseJSON(data)
+	default:
+		values, err = parseProperties(data)
+	}
+	if err != nil {
+		return err
+	}
+	w.mgr.SetSource(w.path, FilePriority, values)
+	return nil
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const waitTimeout = 5 * time.Second
+
+// atomicWriter lays out files in dir the way the kubelet does for ConfigMap and Secret volumes: the files are
+// written to a new ..<timestamp> directory, which the ..data symlink is swapped to by renaming a new symlink over
+// it, and each file is a symlink through ..data.
+type atomicWriter struct {
+	t       *testing.T
+	dir     string
+	seq     int
+	current string
+}
+
+func (a *atomicWriter) write(files map[string]string) {
+	a.seq++
+	tsDir := fmt.Sprintf("..2017_10_%02d_12_00_00.%d", a.seq, a.seq)
+	if err := os.MkdirAll(filepath.Join(a.dir, tsDir), 0755); err != nil {
+		a.t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(a.dir, tsDir, name), []byte(contents), 0644); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+
+	tmpLink := filepath.Join(a.dir, "..data_tmp")
+	if err := os.Symlink(tsDir, tmpLink); err != nil {
+		a.t.Fatal(err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(a.dir, "..data")); err != nil {
+		a.t.Fatal(err)
+	}
+
+	for name := range files {
+		link := filepath.Join(a.dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			if err := os.Symlink(filepath.Join("..data", name), link); err != nil {
+				a.t.Fatal(err)
+			}
+		}
+	}
+	// The kubelet removes links to files that are gone, and the previous directory
+	entries, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Mode()&os.ModeSymlink != 0 && e.Name() != "..data" {
+			if _, ok := files[e.Name()]; !ok {
+				os.Remove(filepath.Join(a.dir, e.Name()))
+			}
+		}
+	}
+	if a.current != "" {
+		os.RemoveAll(filepath.Join(a.dir, a.current))
+	}
+	a.current = tsDir
+}
+
+func newTestManager(t *testing.T) (*Manager, string, func()) {
+	dir, err := ioutil.TempDir("", "authn-proxy-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return newManager(ctx), dir, func() {
+		cancel()
+		os.RemoveAll(dir)
+	}
+}
+
+// waitFor polls until key has value, since reloads happen in the watcher's goroutine.
+func waitFor(t *testing.T, m *Manager, key, value string) {
+	deadline := time.Now().Add(waitTimeout)
+	for m.Get(key) != value {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v is %q, expected %q", key, m.Get(key), value)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatcherReloadsAfterDataSwap(t *testing.T) {
+	m, dir, cleanup := newTestManager(t)
+	defer cleanup()
+	writer := &atomicWriter{t: t, dir: dir}
+	writer.write(map[string]string{"server.properties": "backend.host=a:6443\n"})
+
+	if err := m.AddConfigFile(filepath.Join(dir, "server.properties"), PropertiesFile); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, m, "backend.host", "a:6443")
+
+	writer.write(map[string]string{"server.properties": "backend.host=b:6443\n"})
+	waitFor(t, m, "backend.host", "b:6443")
+
+	writer.write(map[string]string{"server.properties": "backend.host=c:6443\n"})
+	waitFor(t, m, "backend.host", "c:6443")
+}
+
+func TestWatcherLoadsFileAddedLater(t *testing.T) {
+	m, dir, cleanup := newTestManager(t)
+	defer cleanup()
+	writer := &atomicWriter{t: t, dir: dir}
+	writer.write(map[string]string{"other": "x"})
+
+	path := filepath.Join(dir, "server.properties")
+	if err := m.AddConfigFile(path, PropertiesFile); err != nil {
+		t.Fatal(err)
+	}
+	if status := m.Status(); len(status) != 1 || !os.IsNotExist(status[0].Err) {
+		t.Fatalf("expected %v to be reported missing, got %+v", path, status)
+	}
+
+	writer.write(map[string]string{"other": "x", "server.properties": "backend.host=a:6443\n"})
+	waitFor(t, m, "backend.host", "a:6443")
+}
+
+func TestWatcherDropsValuesOfRemovedFile(t *testing.T) {
+	m, dir, cleanup := newTestManager(t)
+	defer cleanup()
+	writer := &atomicWriter{t: t, dir: dir}
+	writer.write(map[string]string{"server.properties": "backend.host=a:6443\n"})
+
+	if err := m.AddConfigFile(filepath.Join(dir, "server.properties"), PropertiesFile); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, m, "backend.host", "a:6443")
+
+	writer.write(map[string]string{"other": "x"})
+	waitFor(t, m, "backend.host", "")
+
+	writer.write(map[string]string{"server.properties": "backend.host=b:6443\n"})
+	waitFor(t, m, "backend.host", "b:6443")
+}
+
+func TestWatcherResyncFindsDirectoryCreatedLater(t *testing.T) {
+	defer func(interval time.Duration) { resyncInterval = interval }(resyncInterval)
+	resyncInterval = 100 * time.Millisecond
+	m, dir, cleanup := newTestManager(t)
+	defer cleanup()
+
+	// Nothing can be watched until the directory exists, so only the resync notices it
+	volume := filepath.Join(dir, "volume")
+	if err := m.AddConfigFile(filepath.Join(volume, "server.properties"), PropertiesFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(volume, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writer := &atomicWriter{t: t, dir: volume}
+	writer.write(map[string]string{"server.properties": "backend.host=a:6443\n"})
+	waitFor(t, m, "backend.host", "a:6443")
+
+	// After the resync the directory is watched too
+	writer.write(map[string]string{"server.properties": "backend.host=b:6443\n"})
+	waitFor(t, m, "backend.host", "b:6443")
+}
+
+func TestWatchFileNotifiesOnDataSwap(t *testing.T) {
+	m, dir, cleanup := newTestManager(t)
+	defer cleanup()
+	writer := &atomicWriter{t: t, dir: dir}
+	writer.write(map[string]string{"tls.crt": "one"})
+
+	changed := make(chan struct{}, 10)
+	if err := m.WatchFile(filepath.Join(dir, "tls.crt"), func() { changed <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	writer.write(map[string]string{"tls.crt": "two"})
+	select {
+	case <-changed:
+	case <-time.After(waitTimeout):
+		t.Fatal("no change notification after swapping ..data")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/certs"
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/health"
+	"github.com/rancher/authn-proxy/proxy"
+	"github.com/rancher/authn-proxy/tlspolicy"
+	"github.com/urfave/cli"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// configCommand checks config before it is rolled out. Its subcommands load config the same way run does, so the
+// global flags and environment variables apply: authn-proxy --config-path <file> config validate.
+var configCommand = cli.Command{
+	Name:  "config",
+	Usage: "Check the config without starting the proxy",
+	Subcommands: []cli.Command{
+		{
+			Name:   "validate",
+			Usage:  "Report unknown keys, invalid values and files that can't be loaded, exiting non-zero if there are any",
+			Action: validateConfig,
+		},
+		{
+			Name:   "dump",
+			Usage:  "Print the effective config and where each value came from, with secrets redacted",
+			Action: dumpConfig,
+		},
+	},
+}
+
+// loadCommandConfig loads config from the flags of the app, which come before the command.
+func loadCommandConfig(ctx context.Context, c *cli.Context) (*config.Manager, error) {
+	for c.Parent() != nil {
+		c = c.Parent()
+	}
+	return loadConfig(ctx, c)
+}
+
+func validateConfig(c *cli.Context) error {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+
+	conf, err := loadCommandConfig(ctx, c)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to load config: %v", err), 1)
+	}
+
+	problems := conf.UnknownKeys()
+	for _, err := range []error{
+		conf.Validate(),
+		health.ConfigCheck(conf).Check(nil),
+		health.TokenCheck(conf).Check(nil),
+		tlsConfigError(conf, "frontend.tls"),
+		certs.CheckConfig(conf),
+		proxy.CheckConfig(conf),
+	} {
+		problems = append(problems, errorMessages(err)...)
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		return cli.NewExitError(fmt.Sprintf("Config is invalid, %v problems found", len(problems)), 1)
+	}
+	fmt.Println("Config is valid")
+	return nil
+}
+
+func tlsConfigError(conf *config.Manager, prefix string) error {
+	_, err := tlspolicy.New(conf, prefix, true)
+	return errors.Wrapf(err, "invalid %v config", prefix)
+}
+
+// errorMessages lists the errors of an aggregate separately.
+func errorMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+	if agg, ok := err.(utilerrors.Aggregate); ok {
+		var result []string
+		for _, e := range agg.Errors() {
+			result = append(result, errorMessages(e)...)
+		}
+		return result
+	}
+	return []string{err.Error()}
+}
+
+func dumpConfig(c *cli.Context) error {
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+
+	conf, err := loadCommandConfig(ctx, c)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to load config: %v", err), 1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, e := range conf.Entries() {
+		value, source := strconv.Quote(e.Value), e.Source
+		if e.Sensitive {
+			value = config.Redacted
+		}
+		if e.Reference != "" {
+			source += " via " + e.Reference
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", e.Key, value, source)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"crypto/x509"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/config"
+)
+
+// ConfigCheck fails if a config file couldn't be parsed, or if no config has been loaded at all, from files, the
+// environment, flags, ConfigMaps or Secrets. Missing config files are tolerated, since the proxy looks in several
+// default locations.
+func ConfigCheck(c *config.Manager) Checker {
+	return NamedCheck("config", func(*http.Request) error {
+		for _, status := range c.Status() {
+			if status.ParseType != config.SingleValueFile && status.Err != nil && !os.IsNotExist(status.Err) {
+				return errors.Wrapf(status.Err, "loading %v", status.Path)
+			}
+		}
+		if len(c.Sources()) == 0 {
+			return errors.New("no config loaded")
+		}
+		return nil
+	})
+}
+
+// TokenCheck fails if the backend token is missing or empty.
+func TokenCheck(c *config.Manager) Checker {
+	return NamedCheck("token", func(*http.Request) error {
+		if strings.TrimSpace(c.Get("token")) == "" {
+			return errors.New("token is empty")
+		}
+		return nil
+	})
+}
+
+// CertCheck fails if any of the certificates returned by leaves isn't currently valid, or if there are none.
+func CertCheck(name string, leaves func() []*x509.Certificate) Checker {
+	return NamedCheck(name, func(*http.Request) error {
+		certs := leaves()
+		if len(certs) == 0 {
+			return errors.New("no certificate loaded")
+		}
+		now := time.Now()
+		for _, cert := range certs {
+			if cert == nil {
+				return errors.New("certificate not loaded")
+			}
+			if now.Before(cert.NotBefore) {
+				return errors.Errorf("certificate %v isn't valid until %v", cert.Subject.CommonName, cert.NotBefore)
+			}
+			if now.After(cert.NotAfter) {
+				return errors.Errorf("certificate %v expired at %v", cert.Subject.CommonName, cert.NotAfter)
+			}
+		}
+		return nil
+	})
+}
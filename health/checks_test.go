@@ -0,0 +1,29 @@
+package health
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rancher/authn-proxy/config"
+)
+
+func TestConfigCheckCountsAnySource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := config.GetManager(ctx)
+	check := ConfigCheck(c)
+
+	if err := check.Check(nil); err == nil {
+		t.Fatal("config check passed with no config loaded")
+	}
+	// Config from only the environment, flags, a ConfigMap or a Secret is enough
+	c.SetSource("env", config.EnvPriority, map[string]string{"backend.host": "a:6443"})
+	if err := check.Check(nil); err != nil {
+		t.Fatalf("config check failed with config from the environment: %v", err)
+	}
+	c.SetSource("env", config.EnvPriority, nil)
+	c.SetSource("configmap/ns/config", config.FilePriority, map[string]string{"backend.host": "a:6443"})
+	if err := check.Check(nil); err != nil {
+		t.Fatalf("config check failed with config from a ConfigMap: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Checker is a single named health check, in the spirit of the apiserver's healthz checks.
+type Checker interface {
+	Name() string
+	Check(req *http.Request) error
+}
+
+type namedCheck struct {
+	name  string
+	check func(req *http.Request) error
+}
+
+// NamedCheck makes a Checker from a function.
+func NamedCheck(name string, check func(req *http.Request) error) Checker {
+	return &namedCheck{
+		name:  name,
+		check: check,
+	}
+}
+
+func (c *namedCheck) Name() string {
+	return c.name
+}
+
+func (c *namedCheck) Check(req *http.Request) error {
+	return c.check(req)
+}
+
+// PingCheck always passes. It only proves the server is handling requests.
+var PingCheck = NamedCheck("ping", func(*http.Request) error { return nil })
+
+// Handler runs checks on every request and responds 200 only if they all pass. With the verbose query parameter,
+// or when a check fails, the result of each check is listed.
+func Handler(name string, checks ...Checker) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		failed := false
+		buf := &bytes.Buffer{}
+		for _, c := range checks {
+			if err := c.Check(req); err != nil {
+				logrus.Debugf("%v check %v failed: %v", name, c.Name(), err)
+				fmt.Fprintf(buf, "[-]%v failed: %v\n", c.Name(), err)
+				failed = true
+			} else {
+				fmt.Fprintf(buf, "[+]%v ok\n", c.Name())
+			}
+		}
+
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(buf, "%v check failed\n", name)
+			rw.Write(buf.Bytes())
+			return
+		}
+
+		if _, verbose := req.URL.Query()["verbose"]; verbose {
+			fmt.Fprintf(buf, "%v check passed\n", name)
+			rw.Write(buf.Bytes())
+			return
+		}
+		rw.Write([]byte("ok"))
+	})
+}
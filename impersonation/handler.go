@@ -3,70 +3,102 @@ package impersonation
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
-	"os"
 
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/rancher/authn-proxy/authnprovider"
 	"github.com/rancher/authn-proxy/config"
-	"github.com/sirupsen/logrus"
+	"github.com/rancher/authn-proxy/ipfilter"
+	"github.com/rancher/authn-proxy/lockout"
+	"github.com/rancher/authn-proxy/metrics"
+	"github.com/rancher/authn-proxy/request"
+	"github.com/rancher/authn-proxy/responsewriters"
 )
 
 const (
-	tokenPath  = "/var/run/secrets/kubernetes.io/serviceaccount/token"
-	configPath = "/var/run/config/cattle.io/config"
+	DefaultTokenPath  = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultConfigPath = "/var/run/config/cattle.io/config"
 )
 
-func NewAuthnHeaderHandler(ctx context.Context, next http.Handler) (http.Handler, error) {
-	c := config.GetManager(ctx)
-
-	tPath := os.Getenv("TOKEN_PATH")
-	if tPath == "" {
-		tPath = tokenPath
-	}
-	if err := c.AddConfigFile(tPath, config.SingleValueFile); err != nil {
-		return nil, errors.Wrapf(err, "couldn't add token config file %v", tPath)
-	}
-
-	cPath := os.Getenv("CONFIG_PATH")
-	if cPath == "" {
-		cPath = configPath
+// LoadConfig adds the token file and the impersonation config file to c.
+func LoadConfig(c *config.Manager, configPath, tokenPath string) error {
+	if err := c.AddConfigFile(tokenPath, config.SingleValueFile); err != nil {
+		return errors.Wrapf(err, "couldn't add token config file %v", tokenPath)
 	}
-	if err := c.AddConfigFile(cPath, config.PropertiesFile); err != nil {
-		return nil, errors.Wrapf(err, "couldn't add config file %v", cPath)
+	if err := c.AddConfigFile(configPath, config.ParseTypeFor(configPath)); err != nil {
+		return errors.Wrapf(err, "couldn't add config file %v", configPath)
 	}
+	return nil
+}
 
-	auth := authnprovider.NewAuthnProvider()
+func NewAuthnHeaderHandler(ctx context.Context, next http.Handler, auth authnprovider.Authenticator, lockouts *lockout.Tracker) (http.Handler, error) {
+	c := config.GetManager(ctx)
 
 	return &authHeaderHandler{
-		auth:   auth,
-		config: c,
-		next:   next,
+		auth:     auth,
+		config:   c,
+		next:     next,
+		lockouts: lockouts,
+		ipFilter: ipfilter.New(c),
 	}, nil
 }
 
 type authHeaderHandler struct {
-	auth   authnprovider.Authenticator
-	next   http.Handler
-	config *config.Manager
+	auth     authnprovider.Authenticator
+	next     http.Handler
+	config   *config.Manager
+	lockouts *lockout.Tracker
+	ipFilter *ipfilter.Filter
 }
 
 func (h authHeaderHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	attemptedUser, clientIP := attemptedUser(req), request.ClientIP(req)
+	request.Log(req).WithField("headers", request.RedactHeaders(req.Header)).Debugf("Handling %v %v from %v", req.Method, request.RedactURL(req.URL), clientIP)
+
+	if ok, scope := h.ipFilter.AllowedGlobally(clientIP); !ok {
+		metrics.IPFilterRejections.WithLabelValues("global").Inc()
+		request.Log(req).Debugf("Rejecting request from %v by %v", clientIP, scope)
+		responsewriters.Forbidden(rw, "Access from this address is not allowed.")
+		return
+	}
+
+	if ok, wait := h.lockouts.Check(attemptedUser, clientIP); !ok {
+		metrics.AuthenticationAttempts.WithLabelValues(h.auth.Name(), "locked").Inc()
+		request.Log(req).Debugf("Rejecting authentication of user %q from %v, locked out for %v", attemptedUser, clientIP, wait)
+		responsewriters.TooManyRequests(rw, "Too many failed authentication attempts, please try again later.", int(math.Ceil(wait.Seconds())))
+		return
+	}
+
 	authed, user, groups, err := h.auth.Authenticate(req)
 	if err != nil {
-		logrus.Errorf("Error encountered while authenticating: %v", err)
+		metrics.AuthenticationAttempts.WithLabelValues(h.auth.Name(), "error").Inc()
+		request.Log(req).Errorf("Error encountered while authenticating: %v", err)
 		// TODO who will handle standardizing the format of 400/500 response bodies?
 		http.Error(rw, "The server encountered a problem", 500)
 		return
 	}
 
 	if !authed {
+		metrics.AuthenticationAttempts.WithLabelValues(h.auth.Name(), "failure").Inc()
+		h.lockouts.Failure(req, attemptedUser, clientIP)
 		http.Error(rw, "Failed authentication", 401)
+		return
 	}
+	metrics.AuthenticationAttempts.WithLabelValues(h.auth.Name(), "success").Inc()
+	h.lockouts.Success(attemptedUser)
 
-	logrus.Debugf("Impersonating user %v, groups %v", user, groups)
+	if ok, scope := h.ipFilter.Allowed(clientIP, user, groups); !ok {
+		metrics.IPFilterRejections.WithLabelValues("user").Inc()
+		request.Log(req).Infof("Rejecting request from %v for user %v by %v", clientIP, user, scope)
+		responsewriters.Forbidden(rw, fmt.Sprintf("User %q is not allowed access from this address.", user))
+		return
+	}
+
+	req = req.WithContext(request.WithUser(req.Context(), &request.User{Name: user, Groups: groups}))
+	request.Log(req).Debugf("Impersonating user %v, groups %v", user, groups)
 
 	req.Header.Set("Impersonate-User", user)
 
@@ -79,3 +111,9 @@ func (h authHeaderHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 
 	h.next.ServeHTTP(rw, req)
 }
+
+// attemptedUser is the username the client tried to log in as, if it sent one.
+func attemptedUser(req *http.Request) string {
+	user, _, _ := req.BasicAuth()
+	return user
+}
@@ -0,0 +1,50 @@
+package ipfilter
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseCIDR parses a CIDR, also accepting a bare IP as a single address.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.Errorf("invalid IP or CIDR %q", s)
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errors.Errorf("invalid IP or CIDR %q", s)
+	}
+	return n, nil
+}
+
+// ParseCIDRs parses a comma separated list of CIDRs or IPs.
+func ParseCIDRs(v string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, s := range strings.Split(v, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		n, err := ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// Contains reports whether ip is in any of nets.
+func Contains(nets []*net.IPNet, ip net.IP) bool {
+	return contains(nets, ip)
+}
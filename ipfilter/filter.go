@@ -0,0 +1,100 @@
+package ipfilter
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/rancher/authn-proxy/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Filter decides which client IPs may use the proxy, from CIDR lists in config:
+// - ipfilter.allow and ipfilter.deny apply to everyone
+// - ipfilter.user.<name>.allow and ipfilter.user.<name>.deny apply to one user
+// - ipfilter.group.<name>.allow and ipfilter.group.<name>.deny apply to members of a group
+// An address is rejected if any applicable deny list contains it, or if any applicable allow list doesn't.
+// Lists are read on every check, so changes to the config file apply immediately.
+type Filter struct {
+	c *config.Manager
+
+	m     sync.Mutex
+	cache map[string][]*net.IPNet
+}
+
+func New(c *config.Manager) *Filter {
+	return &Filter{
+		c:     c,
+		cache: map[string][]*net.IPNet{},
+	}
+}
+
+// AllowedGlobally applies only the lists that apply to everyone, so that rejected clients can be turned away
+// before they get to try credentials.
+func (f *Filter) AllowedGlobally(ip string) (bool, string) {
+	return f.allowed(net.ParseIP(ip), "ipfilter")
+}
+
+// Allowed applies the global lists and those of the user and their groups. When the address is rejected it also
+// returns the scope whose list rejected it.
+func (f *Filter) Allowed(ip string, user string, groups []string) (bool, string) {
+	parsed := net.ParseIP(ip)
+	scopes := []string{"ipfilter", "ipfilter.user." + user}
+	for _, g := range groups {
+		scopes = append(scopes, "ipfilter.group."+g)
+	}
+	for _, scope := range scopes {
+		if ok, _ := f.allowed(parsed, scope); !ok {
+			return false, scope
+		}
+	}
+	return true, ""
+}
+
+func (f *Filter) allowed(ip net.IP, scope string) (bool, string) {
+	if deny := f.list(scope + ".deny"); len(deny) > 0 && (ip == nil || contains(deny, ip)) {
+		return false, scope
+	}
+	if allow := f.list(scope + ".allow"); len(allow) > 0 && (ip == nil || !contains(allow, ip)) {
+		return false, scope
+	}
+	return true, ""
+}
+
+// list parses the CIDR list under key. Parsed lists are cached by value, and invalid entries are logged and skipped since
+// a bad edit to the config file shouldn't take the proxy down.
+func (f *Filter) list(key string) []*net.IPNet {
+	v := f.c.Get(key)
+	if v == "" {
+		return nil
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	if nets, ok := f.cache[v]; ok {
+		return nets
+	}
+	var nets []*net.IPNet
+	for _, s := range strings.Split(v, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		n, err := ParseCIDR(s)
+		if err != nil {
+			logrus.Warnf("Ignoring entry in %v: %v", key, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	f.cache[v] = nets
+	return nets
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/ipfilter"
+)
+
+const (
+	unixPrefix    = "unix://"
+	tcpPrefix     = "tcp://"
+	fdPrefix      = "fd://"
+	systemdPrefix = "systemd://"
+)
+
+// New opens the listener configured by the key prefix (e.g. "frontend.https"). <prefix>.host is one of:
+// - host:port or tcp://host:port
+// - unix:///path/to/socket, with <prefix>.socket.mode (octal), .socket.owner and .socket.group applied if set
+// - fd://<n>, an already open listening socket inherited as file descriptor n
+// - systemd://<name>, a socket passed by systemd socket activation with that FileDescriptorName
+// - systemd://, the first socket passed by systemd
+// If <prefix>.proxy.protocol is true, connections from proxy.protocol.trusted.cidrs may send a PROXY protocol header,
+// as may unix socket peers if proxy.protocol.trusted.unix is true.
+func New(c *config.Manager, prefix string) (net.Listener, error) {
+	l, err := newListener(c, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyProtocol, err := c.GetBool(prefix+".proxy.protocol", false)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	if proxyProtocol {
+		trusted, err := ipfilter.ParseCIDRs(c.Get("proxy.protocol.trusted.cidrs"))
+		if err != nil {
+			l.Close()
+			return nil, errors.Wrap(err, "proxy.protocol.trusted.cidrs")
+		}
+		trustUnix, err := c.GetBool("proxy.protocol.trusted.unix", false)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		if len(trusted) == 0 && !trustUnix {
+			l.Close()
+			return nil, errors.Errorf("%v.proxy.protocol is enabled but proxy.protocol.trusted.cidrs is empty", prefix)
+		}
+		l = newProxyProtoListener(l, trusted, trustUnix)
+	}
+	return l, nil
+}
+
+func newListener(c *config.Manager, prefix string) (net.Listener, error) {
+	address := c.GetString(prefix+".host", "")
+	switch {
+	case strings.HasPrefix(address, unixPrefix):
+		return newUnix(c, prefix, strings.TrimPrefix(address, unixPrefix))
+	case strings.HasPrefix(address, fdPrefix):
+		fd, err := strconv.Atoi(strings.TrimPrefix(address, fdPrefix))
+		if err != nil {
+			return nil, errors.Errorf("%v.host: invalid file descriptor in %q", prefix, address)
+		}
+		return fileListener(uintptr(fd), address)
+	case strings.HasPrefix(address, systemdPrefix):
+		return systemdListener(strings.TrimPrefix(address, systemdPrefix))
+	default:
+		return net.Listen("tcp", strings.TrimPrefix(address, tcpPrefix))
+	}
+}
+
+func newUnix(c *config.Manager, prefix, path string) (net.Listener, error) {
+	// A socket left behind by an unclean exit would make listening fail
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrapf(err, "couldn't remove stale socket %v", path)
+		}
+	}
+
+	l, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setSocketPermissions(c, prefix, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// umaskLock keeps sockets from being created concurrently, since the umask is shared by the whole process
+var umaskLock sync.Mutex
+
+// listenPrivate creates the socket accessible only to the proxy's user, so no one else can connect before
+// setSocketPermissions sets the configured mode and owner.
+func listenPrivate(path string) (net.Listener, error) {
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
+
+func setSocketPermissions(c *config.Manager, prefix, path string) error {
+	if v := c.GetString(prefix+".socket.mode", ""); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return errors.Errorf("%v.socket.mode: invalid octal mode %q", prefix, v)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			return errors.Wrapf(err, "couldn't set mode of %v", path)
+		}
+	}
+
+	uid, gid := -1, -1
+	if v := c.GetString(prefix+".socket.owner", ""); v != "" {
+		id, err := lookupID(v, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "%v.socket.owner", prefix)
+		}
+		uid = id
+	}
+	if v := c.GetString(prefix+".socket.group", ""); v != "" {
+		id, err := lookupID(v, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "%v.socket.group", prefix)
+		}
+		gid = id
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return errors.Wrapf(err, "couldn't set owner of %v", path)
+		}
+	}
+	return nil
+}
+
+// lookupID accepts a numeric id as is, and otherwise resolves the name with lookup.
+func lookupID(v string, lookup func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(v); err == nil {
+		return id, nil
+	}
+	s, err := lookup(v)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+func fileListener(fd uintptr, name string) (net.Listener, error) {
+	f := os.NewFile(fd, name)
+	if f == nil {
+		return nil, errors.Errorf("invalid file descriptor %v", fd)
+	}
+	// FileListener dups the descriptor, so the original can be closed
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "file descriptor %v isn't a listening socket", fd)
+	}
+	return l, nil
+}
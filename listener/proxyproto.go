@@ -0,0 +1,208 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/ipfilter"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	proxyHeaderTimeout = 10 * time.Second
+
+	// The longest v1 header, see section 2.1 of the spec
+	v1MaxLength = 107
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyProtoListener accepts connections that may start with a PROXY protocol (v1 or v2) header, as sent by load
+// balancers like AWS NLB and HAProxy, and reports the client address from the header as the connection's remote
+// address. Only connections from trusted addresses may use the header; for others it is left in the stream, where
+// it fails the TLS handshake or HTTP parsing, so a client can't spoof its address. Connections from trusted
+// addresses without a header, like load balancer health checks, are served as is. Any local process that can open
+// a unix socket could spoof its address, so unix peers are only trusted if trustUnix is set.
+type proxyProtoListener struct {
+	net.Listener
+	trusted   []*net.IPNet
+	trustUnix bool
+}
+
+func newProxyProtoListener(l net.Listener, trusted []*net.IPNet, trustUnix bool) net.Listener {
+	return &proxyProtoListener{
+		Listener:  l,
+		trusted:   trusted,
+		trustUnix: trustUnix,
+	}
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{
+		Conn:   conn,
+		reader: bufio.NewReaderSize(conn, 256),
+	}, nil
+}
+
+func (l *proxyProtoListener) isTrusted(addr net.Addr) bool {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return ipfilter.Contains(l.trusted, tcpAddr.IP)
+	}
+	// Peers of unix sockets usually have no address, so go by the listener's
+	if _, ok := l.Listener.Addr().(*net.UnixAddr); ok {
+		return l.trustUnix
+	}
+	return false
+}
+
+// proxyConn reads the header on first use rather than in Accept, so a slow client can't stall the accept loop.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	var err error
+	c.remoteAddr, err = parseProxyHeader(c.reader)
+	if err != nil {
+		logrus.Debugf("Invalid PROXY protocol header from %v: %v", c.Conn.RemoteAddr(), err)
+		c.err = err
+		c.Conn.Close()
+	}
+}
+
+// parseProxyHeader consumes a header if r starts with one. It returns a nil address for connections without a
+// header and for headers that carry no address (v1 UNKNOWN, v2 LOCAL or unsupported families).
+func parseProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	peek, err := r.Peek(len(v2Signature))
+	if err != nil && len(peek) < len(v1Prefix) {
+		// Too short for a header, let the next reader deal with it
+		return nil, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(peek, v1Prefix):
+		return parseV1(r)
+	case bytes.Equal(peek, v2Signature):
+		return parseV2(r)
+	}
+	return nil, nil
+}
+
+func parseV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header too long or not terminated by CRLF")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.Errorf("malformed v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errors.Errorf("malformed v1 header %q", strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func parseV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	version, command := header[12]>>4, header[12]&0x0f
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if version != 2 {
+		return nil, errors.Errorf("unsupported v2 header version %d", version)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	const (
+		commandLocal = 0x0
+		commandProxy = 0x1
+		tcpOverIPv4  = 0x11
+		tcpOverIPv6  = 0x21
+	)
+	switch command {
+	case commandLocal:
+		return nil, nil
+	case commandProxy:
+	default:
+		return nil, errors.Errorf("unsupported v2 command %d", command)
+	}
+
+	switch family {
+	case tcpOverIPv4:
+		if length < 12 {
+			return nil, errors.New("v2 header too short for IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case tcpOverIPv6:
+		if length < 36 {
+			return nil, errors.New("v2 header too short for IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	return nil, nil
+}
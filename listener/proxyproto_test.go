@@ -0,0 +1,172 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// v2Header builds a v2 header with the given version and command byte, family and payload.
+func v2Header(versionCommand, family byte, payload []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, versionCommand, family, 0, 0)
+	binary.BigEndian.PutUint16(h[14:16], uint16(len(payload)))
+	return append(h, payload...)
+}
+
+func v2IPv4Payload(src, dst string, srcPort, dstPort uint16) []byte {
+	p := append(append([]byte{}, net.ParseIP(src).To4()...), net.ParseIP(dst).To4()...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], srcPort)
+	binary.BigEndian.PutUint16(ports[2:4], dstPort)
+	return append(p, ports...)
+}
+
+func v2IPv6Payload(src, dst string, srcPort, dstPort uint16) []byte {
+	p := append(append([]byte{}, net.ParseIP(src).To16()...), net.ParseIP(dst).To16()...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], srcPort)
+	binary.BigEndian.PutUint16(ports[2:4], dstPort)
+	return append(p, ports...)
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		addr  string
+		rest  string
+	}{
+		{"no header", []byte("GET / HTTP/1.1\r\n"), "", "GET / HTTP/1.1\r\n"},
+		{"too short for a header", []byte("GET"), "", "GET"},
+		{"v1 TCP4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET /"), "192.0.2.1:56324", "GET /"},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET /"), "[2001:db8::1]:56324", "GET /"},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\nGET /"), "", "GET /"},
+		{"v2 IPv4", append(v2Header(0x21, 0x11, v2IPv4Payload("192.0.2.1", "198.51.100.1", 56324, 443)), "GET /"...), "192.0.2.1:56324", "GET /"},
+		{"v2 IPv6", append(v2Header(0x21, 0x21, v2IPv6Payload("2001:db8::1", "2001:db8::2", 56324, 443)), "GET /"...), "[2001:db8::1]:56324", "GET /"},
+		{"v2 IPv4 with TLVs", append(v2Header(0x21, 0x11, append(v2IPv4Payload("192.0.2.1", "198.51.100.1", 1, 443), 0x04, 0, 1, 'x')), "GET /"...), "192.0.2.1:1", "GET /"},
+		{"v2 LOCAL", append(v2Header(0x20, 0x00, nil), "GET /"...), "", "GET /"},
+		{"v2 unix family", append(v2Header(0x21, 0x31, make([]byte, 216)), "GET /"...), "", "GET /"},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReaderSize(bytes.NewReader(tt.input), 256)
+		addr, err := parseProxyHeader(r)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.name, err)
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.addr {
+			t.Errorf("%v: got address %q, want %q", tt.name, got, tt.addr)
+		}
+		if rest, _ := ioutil.ReadAll(r); string(rest) != tt.rest {
+			t.Errorf("%v: left %q in the stream, want %q", tt.name, rest, tt.rest)
+		}
+	}
+}
+
+func TestParseProxyHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.1 198.51")},
+		{"v1 without CRLF", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\nGET /")},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n")},
+		{"v1 unknown protocol", []byte("PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n")},
+		{"v1 missing fields", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n")},
+		{"v1 bad address", []byte("PROXY TCP4 192.0.2.x 198.51.100.1 56324 443\r\n")},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n")},
+		{"v1 negative port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 -1 443\r\n")},
+		{"v2 truncated fixed header", v2Header(0x21, 0x11, nil)[:14]},
+		{"v2 truncated payload", v2Header(0x21, 0x11, v2IPv4Payload("192.0.2.1", "198.51.100.1", 1, 443))[:20]},
+		{"v2 bad version", v2Header(0x11, 0x11, v2IPv4Payload("192.0.2.1", "198.51.100.1", 1, 443))},
+		{"v2 bad command", v2Header(0x22, 0x11, v2IPv4Payload("192.0.2.1", "198.51.100.1", 1, 443))},
+		{"v2 IPv4 payload too short", v2Header(0x21, 0x11, make([]byte, 8))},
+		{"v2 IPv6 payload too short", v2Header(0x21, 0x21, make([]byte, 20))},
+	}
+
+	for _, tt := range tests {
+		if addr, err := parseProxyHeader(bufio.NewReaderSize(bytes.NewReader(tt.input), 256)); err == nil {
+			t.Errorf("%v: expected an error, got address %v", tt.name, addr)
+		}
+	}
+}
+
+// remoteAddrOf connects to l, sends data and returns the remote address l's connection reports.
+func remoteAddrOf(t *testing.T, l net.Listener, network, address string, data []byte) string {
+	client, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.RemoteAddr().String()
+}
+
+func TestProxyProtoListenerTrust(t *testing.T) {
+	header := []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inner.Close()
+
+	l := newProxyProtoListener(inner, []*net.IPNet{loopback}, false)
+	if got := remoteAddrOf(t, l, "tcp", inner.Addr().String(), header); got != "192.0.2.1:56324" {
+		t.Errorf("trusted peer's header ignored, remote address is %v", got)
+	}
+	// An untrusted peer's header is left in the stream rather than spoofing its address
+	l = newProxyProtoListener(inner, []*net.IPNet{other}, false)
+	if got := remoteAddrOf(t, l, "tcp", inner.Addr().String(), header); !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("untrusted peer spoofed remote address %v", got)
+	}
+}
+
+func TestProxyProtoListenerUnixTrust(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authn-proxy-listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.sock")
+	header := []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+
+	inner, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inner.Close()
+
+	// Trusting every IP says nothing about local processes
+	l := newProxyProtoListener(inner, []*net.IPNet{all}, false)
+	if got := remoteAddrOf(t, l, "unix", path, header); got == "192.0.2.1:56324" {
+		t.Error("unix peer spoofed its address without proxy.protocol.trusted.unix")
+	}
+	l = newProxyProtoListener(inner, nil, true)
+	if got := remoteAddrOf(t, l, "unix", path, header); got != "192.0.2.1:56324" {
+		t.Errorf("trusted unix peer's header ignored, remote address is %v", got)
+	}
+}
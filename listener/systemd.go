@@ -0,0 +1,65 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Passed file descriptors start after stdin, stdout and stderr, see sd_listen_fds(3)
+const listenFdsStart = 3
+
+var (
+	systemdOnce  sync.Once
+	systemdNames []string
+	systemdErr   error
+)
+
+// systemdListener returns the socket systemd passed under name, or the first one if name is empty.
+func systemdListener(name string) (net.Listener, error) {
+	systemdOnce.Do(func() {
+		systemdNames, systemdErr = listenFds()
+	})
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+
+	for i, n := range systemdNames {
+		if name == "" || n == name {
+			return fileListener(uintptr(listenFdsStart+i), "systemd:"+n)
+		}
+	}
+	return nil, errors.Errorf("systemd didn't pass a socket named %q", name)
+}
+
+// listenFds reads the socket activation environment, returning the name of each passed descriptor. The variables
+// are unset so child processes don't mistake the sockets for their own.
+func listenFds() ([]string, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd: LISTEN_PID isn't set to this process")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("no sockets passed by systemd: LISTEN_FDS isn't set")
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	result := make([]string, count)
+	for i := range result {
+		if i < len(names) && names[i] != "" {
+			result[i] = names[i]
+		} else {
+			result[i] = "unknown"
+		}
+	}
+	return result, nil
+}
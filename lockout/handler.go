@@ -0,0 +1,37 @@
+package lockout
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Handler serves the admin API for lockouts. It doesn't authenticate requests itself, admin.Handler does:
+// - GET lists current lockouts
+// - DELETE with a user or ip query parameter clears that key
+func Handler(t *Tracker) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			rw.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(rw).Encode(t.Lockouts())
+		case http.MethodDelete:
+			q := req.URL.Query()
+			kind, key := KindUser, q.Get("user")
+			if key == "" {
+				kind, key = KindIP, q.Get("ip")
+			}
+			if key == "" {
+				http.Error(rw, "user or ip parameter is required", http.StatusBadRequest)
+				return
+			}
+			if !t.Clear(req, kind, key) {
+				http.Error(rw, "no lockout found", http.StatusNotFound)
+				return
+			}
+			rw.WriteHeader(http.StatusNoContent)
+		default:
+			rw.Header().Set("Allow", "GET, DELETE")
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,335 @@
+package lockout
+
+import (
+	"container/heap"
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/metrics"
+	"github.com/rancher/authn-proxy/request"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	KindUser = "user"
+	KindIP   = "ip"
+
+	defaultUserThreshold = 5
+	defaultIPThreshold   = 20
+	defaultDuration      = time.Minute
+	defaultMaxDuration   = time.Hour
+	defaultWindow        = 15 * time.Minute
+	defaultMaxRecords    = 100000
+
+	cleanupInterval = time.Minute
+)
+
+// Tracker counts failed authentications per username and per client IP. Once a key reaches its threshold of
+// failures it is locked out, for a duration that doubles with every consecutive lockout up to a maximum.
+// Failures older than the window are forgotten, as is the lockout history once a key has been quiet for a window
+// after its last lockout expired. At most maxRecords keys of each kind are tracked, so that failures for made up
+// usernames or from many addresses can't use unbounded memory.
+type Tracker struct {
+	m           sync.Mutex
+	thresholds  map[string]int
+	duration    time.Duration
+	maxDuration time.Duration
+	window      time.Duration
+	maxRecords  int
+	records     map[string]map[string]*record
+	// active counts the current lockouts of each kind, and expiries holds them ordered by when they end, so that
+	// the gauge is kept up to date without scanning every record.
+	active   map[string]int
+	expiries expiryHeap
+	// full records for which kinds a warning that no more keys are tracked has been logged
+	full map[string]bool
+}
+
+type record struct {
+	failures    int
+	lastFailure time.Time
+	lockouts    uint
+	lockedUntil time.Time
+}
+
+// Lockout describes a key that is currently locked out.
+type Lockout struct {
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	Lockouts    uint      `json:"lockouts"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+// expiry is when the lockout of a key ends.
+type expiry struct {
+	kind  string
+	key   string
+	until time.Time
+}
+
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].until.Before(h[j].until) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func NewTracker(ctx context.Context) (*Tracker, error) {
+	c := config.GetManager(ctx)
+	t := newTracker()
+
+	var err error
+	if t.thresholds[KindUser], err = c.GetInt("lockout.user.threshold", t.thresholds[KindUser]); err != nil {
+		return nil, err
+	}
+	if t.thresholds[KindIP], err = c.GetInt("lockout.ip.threshold", t.thresholds[KindIP]); err != nil {
+		return nil, err
+	}
+	if t.duration, err = c.GetDuration("lockout.duration", t.duration); err != nil {
+		return nil, err
+	}
+	if t.maxDuration, err = c.GetDuration("lockout.max.duration", t.maxDuration); err != nil {
+		return nil, err
+	}
+	if t.window, err = c.GetDuration("lockout.window", t.window); err != nil {
+		return nil, err
+	}
+	if t.maxRecords, err = c.GetInt("lockout.max.records", t.maxRecords); err != nil {
+		return nil, err
+	}
+
+	go t.cleanup(ctx)
+	return t, nil
+}
+
+func newTracker() *Tracker {
+	return &Tracker{
+		thresholds: map[string]int{
+			KindUser: defaultUserThreshold,
+			KindIP:   defaultIPThreshold,
+		},
+		duration:    defaultDuration,
+		maxDuration: defaultMaxDuration,
+		window:      defaultWindow,
+		maxRecords:  defaultMaxRecords,
+		records: map[string]map[string]*record{
+			KindUser: {},
+			KindIP:   {},
+		},
+		active: map[string]int{},
+		full:   map[string]bool{},
+	}
+}
+
+// Check returns whether an authentication attempt for user from ip may proceed, and if not, how long until it may.
+// Either key may be empty.
+func (t *Tracker) Check(user, ip string) (bool, time.Duration) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for kind, key := range map[string]string{KindUser: user, KindIP: ip} {
+		if r, ok := t.records[kind][key]; ok && key != "" && r.lockedUntil.After(now) {
+			if d := r.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait == 0, wait
+}
+
+// Failure records a failed authentication attempt by req.
+func (t *Tracker) Failure(req *http.Request, user, ip string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	now := time.Now()
+	t.expire(now)
+	for kind, key := range map[string]string{KindUser: user, KindIP: ip} {
+		if key == "" || t.thresholds[kind] <= 0 {
+			continue
+		}
+
+		r, ok := t.records[kind][key]
+		if !ok {
+			if t.maxRecords > 0 && len(t.records[kind]) >= t.maxRecords {
+				// Evicting records would let a flood of failures clear real lockouts, so new keys aren't tracked
+				// until the cleanup makes room
+				if !t.full[kind] {
+					t.full[kind] = true
+					logrus.Warnf("Tracking failed authentications of %v %v keys, not tracking more until old ones expire", len(t.records[kind]), kind)
+				}
+				continue
+			}
+			r = &record{}
+			t.records[kind][key] = r
+		}
+		if now.Sub(r.lastFailure) > t.window {
+			r.failures = 0
+		}
+		r.failures++
+		r.lastFailure = now
+
+		if r.failures >= t.thresholds[kind] {
+			if !r.lockedUntil.After(now) {
+				t.active[kind]++
+			}
+			r.failures = 0
+			r.lockouts++
+			r.lockedUntil = now.Add(t.lockoutDuration(r.lockouts))
+			heap.Push(&t.expiries, expiry{kind: kind, key: key, until: r.lockedUntil})
+			metrics.Lockouts.WithLabelValues(kind).Inc()
+			request.Log(req).WithFields(logrus.Fields{
+				"event":       "lockout",
+				"kind":        kind,
+				"key":         key,
+				"lockouts":    r.lockouts,
+				"lockedUntil": r.lockedUntil.Format(time.RFC3339),
+			}).Warnf("Locking out %v %v after repeated authentication failures", kind, key)
+		}
+	}
+	t.updateGauge()
+}
+
+// Success forgets the failures of user. Failures of the client IP are kept, since one valid login from an address
+// says nothing about the other usernames being tried from it.
+func (t *Tracker) Success(user string) {
+	if user == "" {
+		return
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	now := time.Now()
+	t.expire(now)
+	if r, ok := t.records[KindUser][user]; ok && r.lockedUntil.Before(now) {
+		delete(t.records[KindUser], user)
+	}
+}
+
+// Lockouts lists the keys that are currently locked out.
+func (t *Tracker) Lockouts() []Lockout {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	now := time.Now()
+	result := []Lockout{}
+	for kind, records := range t.records {
+		for key, r := range records {
+			if r.lockedUntil.After(now) {
+				result = append(result, Lockout{
+					Kind:        kind,
+					Key:         key,
+					Lockouts:    r.lockouts,
+					LockedUntil: r.lockedUntil,
+				})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// Clear removes the lockout and failure history of key, as asked by req. It returns false if nothing was tracked
+// for it.
+func (t *Tracker) Clear(req *http.Request, kind, key string) bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	records, ok := t.records[kind]
+	if !ok {
+		return false
+	}
+	r, ok := records[key]
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	t.expire(now)
+	if r.lockedUntil.After(now) {
+		t.active[kind]--
+	}
+	delete(records, key)
+	request.Log(req).WithFields(logrus.Fields{
+		"event": "lockout-cleared",
+		"kind":  kind,
+		"key":   key,
+	}).Infof("Cleared lockout of %v %v", kind, key)
+	t.updateGauge()
+	return true
+}
+
+func (t *Tracker) lockoutDuration(lockouts uint) time.Duration {
+	d := t.duration
+	for i := uint(1); i < lockouts && d < t.maxDuration; i++ {
+		d *= 2
+	}
+	if d > t.maxDuration {
+		d = t.maxDuration
+	}
+	return d
+}
+
+// expire stops counting lockouts that ended by now. A lockout whose record was cleared or locked again since is
+// skipped, it was already accounted for then. It must be called with the lock held.
+func (t *Tracker) expire(now time.Time) {
+	for len(t.expiries) > 0 && !t.expiries[0].until.After(now) {
+		e := heap.Pop(&t.expiries).(expiry)
+		if r, ok := t.records[e.kind][e.key]; ok && r.lockedUntil.Equal(e.until) {
+			t.active[e.kind]--
+		}
+	}
+}
+
+// updateGauge must be called with the lock held.
+func (t *Tracker) updateGauge() {
+	for kind := range t.records {
+		metrics.ActiveLockouts.WithLabelValues(kind).Set(float64(t.active[kind]))
+	}
+}
+
+// prune forgets keys that have been quiet for a window. It must be called with the lock held.
+func (t *Tracker) prune(now time.Time) {
+	t.expire(now)
+	for kind, records := range t.records {
+		for key, r := range records {
+			if now.Sub(r.lastFailure) > t.window && now.Sub(r.lockedUntil) > t.window {
+				delete(records, key)
+			}
+		}
+		if len(records) < t.maxRecords {
+			t.full[kind] = false
+		}
+	}
+	t.updateGauge()
+}
+
+func (t *Tracker) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.m.Lock()
+			t.prune(time.Now())
+			t.m.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
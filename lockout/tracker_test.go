@@ -0,0 +1,155 @@
+package lockout
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func fail(t *Tracker, n int, user, ip string) {
+	for i := 0; i < n; i++ {
+		t.Failure(httptest.NewRequest("GET", "/", nil), user, ip)
+	}
+}
+
+func TestLockoutAfterThreshold(t *testing.T) {
+	tr := newTracker()
+	tr.thresholds[KindUser] = 3
+	tr.thresholds[KindIP] = 5
+
+	fail(tr, 2, "alice", "10.0.0.1")
+	if ok, _ := tr.Check("alice", "10.0.0.1"); !ok {
+		t.Fatal("locked out before reaching the threshold")
+	}
+	fail(tr, 1, "alice", "10.0.0.1")
+	if ok, wait := tr.Check("alice", "10.0.0.2"); ok || wait <= 0 || wait > defaultDuration {
+		t.Fatalf("user not locked out after threshold, ok %v wait %v", ok, wait)
+	}
+	if ok, _ := tr.Check("bob", "10.0.0.1"); !ok {
+		t.Fatal("client IP locked out before its threshold")
+	}
+
+	// Failures for other usernames count towards the client IP's threshold
+	fail(tr, 2, "bob", "10.0.0.1")
+	if ok, _ := tr.Check("carol", "10.0.0.1"); ok {
+		t.Fatal("client IP not locked out after threshold")
+	}
+	if tr.active[KindUser] != 1 || tr.active[KindIP] != 1 {
+		t.Fatalf("active lockouts are %v", tr.active)
+	}
+	if lockouts := tr.Lockouts(); len(lockouts) != 2 || lockouts[0].Key != "10.0.0.1" || lockouts[1].Key != "alice" {
+		t.Fatalf("unexpected lockouts %+v", lockouts)
+	}
+}
+
+func TestDisabledThreshold(t *testing.T) {
+	tr := newTracker()
+	tr.thresholds[KindUser] = 0
+	fail(tr, 100, "alice", "")
+	if ok, _ := tr.Check("alice", ""); !ok {
+		t.Fatal("locked out with threshold 0")
+	}
+	if len(tr.records[KindUser]) != 0 {
+		t.Fatal("failures tracked with threshold 0")
+	}
+}
+
+func TestSuccessForgetsUserFailures(t *testing.T) {
+	tr := newTracker()
+	tr.thresholds[KindUser] = 2
+	tr.thresholds[KindIP] = 2
+
+	fail(tr, 1, "alice", "10.0.0.1")
+	tr.Success("alice")
+	fail(tr, 1, "alice", "")
+	if ok, _ := tr.Check("alice", ""); !ok {
+		t.Fatal("failures before a success still counted")
+	}
+	// The client IP's failures are kept
+	fail(tr, 1, "", "10.0.0.1")
+	if ok, _ := tr.Check("", "10.0.0.1"); ok {
+		t.Fatal("success cleared the client IP's failures")
+	}
+}
+
+func TestLockoutDurationDoubles(t *testing.T) {
+	tr := newTracker()
+	tr.duration = time.Minute
+	tr.maxDuration = 5 * time.Minute
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if got := tr.lockoutDuration(uint(i + 1)); got != want {
+			t.Errorf("lockout %v lasts %v, expected %v", i+1, got, want)
+		}
+	}
+}
+
+func TestActiveLockoutsExpire(t *testing.T) {
+	tr := newTracker()
+	tr.thresholds[KindUser] = 1
+	tr.duration = 20 * time.Millisecond
+	tr.maxDuration = time.Hour
+
+	fail(tr, 1, "alice", "")
+	fail(tr, 1, "bob", "")
+	if tr.active[KindUser] != 2 {
+		t.Fatalf("active lockouts are %v, expected 2", tr.active[KindUser])
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	// Locking alice out again ends the first lockout and starts a longer one, which is only counted once
+	fail(tr, 1, "alice", "")
+	if tr.active[KindUser] != 1 {
+		t.Fatalf("active lockouts are %v after bob's expired, expected 1", tr.active[KindUser])
+	}
+	if r := tr.records[KindUser]["alice"]; r.lockouts != 2 {
+		t.Fatalf("alice has %v lockouts, expected 2", r.lockouts)
+	}
+
+	// Clearing an active lockout stops counting it, and its expiry isn't counted again
+	if !tr.Clear(httptest.NewRequest("DELETE", "/", nil), KindUser, "alice") {
+		t.Fatal("clearing alice's lockout failed")
+	}
+	if tr.active[KindUser] != 0 {
+		t.Fatalf("active lockouts are %v after clear, expected 0", tr.active[KindUser])
+	}
+	tr.expire(time.Now().Add(time.Hour))
+	if tr.active[KindUser] != 0 || len(tr.expiries) != 0 {
+		t.Fatalf("active lockouts are %v with %v expiries after all expired", tr.active[KindUser], len(tr.expiries))
+	}
+	if tr.Clear(httptest.NewRequest("DELETE", "/", nil), KindUser, "alice") {
+		t.Fatal("clearing an untracked key succeeded")
+	}
+}
+
+func TestMaxRecords(t *testing.T) {
+	tr := newTracker()
+	tr.thresholds[KindUser] = 2
+	tr.maxRecords = 2
+	tr.window = 20 * time.Millisecond
+
+	fail(tr, 1, "alice", "")
+	fail(tr, 1, "bob", "")
+	fail(tr, 2, "carol", "")
+	if len(tr.records[KindUser]) != 2 {
+		t.Fatalf("tracking %v users, expected the limit of 2", len(tr.records[KindUser]))
+	}
+	if ok, _ := tr.Check("carol", ""); !ok {
+		t.Fatal("untracked user locked out")
+	}
+	// Keys already tracked keep counting
+	fail(tr, 1, "alice", "")
+	if ok, _ := tr.Check("alice", ""); ok {
+		t.Fatal("tracked user not locked out once the limit was reached")
+	}
+
+	// Once the failures are forgotten there's room again
+	time.Sleep(50 * time.Millisecond)
+	tr.prune(time.Now())
+	if len(tr.records[KindUser]) != 1 {
+		t.Fatalf("tracking %v users after pruning, expected only locked out alice", len(tr.records[KindUser]))
+	}
+	fail(tr, 2, "carol", "")
+	if ok, _ := tr.Check("carol", ""); ok {
+		t.Fatal("user not locked out after pruning made room")
+	}
+}
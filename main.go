@@ -1,64 +1,450 @@
 package main
 
 import (
+	"crypto/tls"
+	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"context"
 
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/accesslog"
+	"github.com/rancher/authn-proxy/admin"
+	"github.com/rancher/authn-proxy/authnprovider"
+	"github.com/rancher/authn-proxy/certs"
 	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/health"
 	"github.com/rancher/authn-proxy/impersonation"
+	"github.com/rancher/authn-proxy/ipfilter"
+	"github.com/rancher/authn-proxy/listener"
+	"github.com/rancher/authn-proxy/lockout"
+	"github.com/rancher/authn-proxy/metrics"
 	"github.com/rancher/authn-proxy/proxy"
+	"github.com/rancher/authn-proxy/request"
+	"github.com/rancher/authn-proxy/server"
+	"github.com/rancher/authn-proxy/throttle"
+	"github.com/rancher/authn-proxy/tlspolicy"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
+const (
+	defaultGracePeriod = 30 * time.Second
+)
+
 func main() {
 	app := cli.NewApp()
+	app.Usage = "Authenticate requests and forward them to kubernetes with impersonation headers"
+	app.Flags = append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "config-path",
+			Value:  proxy.DefaultConfigPath,
+			Usage:  "Config file of the proxy",
+			EnvVar: "AUTHN_PROXY_CONFIG_PATH,CONFIG_PATH",
+		},
+		cli.StringFlag{
+			Name:   "impersonation-config-path",
+			Value:  impersonation.DefaultConfigPath,
+			Usage:  "Config file of the impersonation handler",
+			EnvVar: "AUTHN_PROXY_IMPERSONATION_CONFIG_PATH",
+		},
+		cli.StringFlag{
+			Name:   "token-path",
+			Value:  impersonation.DefaultTokenPath,
+			Usage:  "File containing the service account token sent to the backend",
+			EnvVar: "AUTHN_PROXY_TOKEN_PATH,TOKEN_PATH",
+		},
+		cli.StringFlag{
+			Name:   "config-precedence",
+			Usage:  "Comma separated config file paths, highest precedence first",
+			EnvVar: "AUTHN_PROXY_CONFIG_PRECEDENCE,CONFIG_PRECEDENCE",
+		},
+		cli.StringSliceFlag{
+			Name:   "config-map",
+			Usage:  "ConfigMap to read config from through the Kubernetes API, as <namespace>/<name> or <name>",
+			EnvVar: "AUTHN_PROXY_CONFIG_MAP",
+		},
+		cli.StringSliceFlag{
+			Name:   "config-secret",
+			Usage:  "Secret to read config from through the Kubernetes API, as <namespace>/<name> or <name>",
+			EnvVar: "AUTHN_PROXY_CONFIG_SECRET",
+		},
+	}, config.Flags()...)
+	app.Commands = []cli.Command{configCommand}
 	app.Action = run
 	app.Run(os.Args)
 }
 
+// loadConfig loads the config files and Kubernetes objects, then the environment and flags which override them.
+func loadConfig(ctx context.Context, c *cli.Context) (*config.Manager, error) {
+	warnLegacyEnv()
+	conf := config.GetManager(ctx)
+	conf.SetPrecedence(strings.Split(c.String("config-precedence"), ",")...)
+	if err := proxy.LoadConfig(conf, c.String("config-path")); err != nil {
+		return nil, err
+	}
+	if err := impersonation.LoadConfig(conf, c.String("impersonation-config-path"), c.String("token-path")); err != nil {
+		return nil, err
+	}
+	if err := loadKubeConfig(conf, c); err != nil {
+		return nil, err
+	}
+	conf.LoadEnv()
+	conf.LoadFlags(c)
+	return conf, nil
+}
+
+func loadKubeConfig(conf *config.Manager, c *cli.Context) error {
+	configMaps, secrets := c.StringSlice("config-map"), c.StringSlice("config-secret")
+	if len(configMaps) == 0 && len(secrets) == 0 {
+		return nil
+	}
+	client, err := config.InClusterClient()
+	if err != nil {
+		return errors.Wrap(err, "couldn't create Kubernetes API client")
+	}
+	for _, ref := range configMaps {
+		if err := conf.AddConfigMap(client, ref); err != nil {
+			return errors.Wrapf(err, "couldn't add ConfigMap %v", ref)
+		}
+	}
+	for _, ref := range secrets {
+		if err := conf.AddSecret(client, ref); err != nil {
+			return errors.Wrapf(err, "couldn't add Secret %v", ref)
+		}
+	}
+	return nil
+}
+
+// legacyEnv maps the environment variables that predate the AUTHN_PROXY_ prefix to their replacements.
+var legacyEnv = map[string]string{
+	"CONFIG_PATH":       "AUTHN_PROXY_CONFIG_PATH",
+	"TOKEN_PATH":        "AUTHN_PROXY_TOKEN_PATH",
+	"CONFIG_PRECEDENCE": "AUTHN_PROXY_CONFIG_PRECEDENCE",
+}
+
+func warnLegacyEnv() {
+	for old, replacement := range legacyEnv {
+		if _, ok := os.LookupEnv(old); ok {
+			logrus.Warnf("%v is deprecated and will be removed, use %v instead", old, replacement)
+		}
+	}
+}
+
 func run(c *cli.Context) {
 	logrus.Infof("Configuring...")
 
 	ctx, cancelF := context.WithCancel(context.Background())
 	defer cancelF()
 
+	conf, err := loadConfig(ctx, c)
+	if err != nil {
+		logrus.Fatalf("Failed to load config: %v", err)
+	}
+	// Set the format first, so that errors in the rest of the config are logged in it. The standard logger, which
+	// net/http logs errors to, is sent through logrus to use the same format.
+	setLogFormat(conf.GetString("log.format", "text"))
+	log.SetFlags(0)
+	log.SetOutput(logrus.StandardLogger().Writer())
+	for _, msg := range conf.UnknownKeys() {
+		logrus.Warn(msg)
+	}
+	if err := conf.Validate(); err != nil {
+		logrus.Fatalf("Invalid config: %v", err)
+	}
+
+	setLogLevel(conf.GetString("log.level", "info"))
+	conf.Watch(func(changes []config.Change) {
+		setLogFormat(conf.GetString("log.format", "text"))
+		setLogLevel(conf.GetString("log.level", "info"))
+	}, "log.level", "log.format")
+
+	httpHost, httpsHost := conf.GetString("frontend.http.host", ""), conf.GetString("frontend.https.host", "")
+	if httpHost == "" && httpsHost == "" {
+		logrus.Fatalf("Neither frontend.http.host nor frontend.https.host is set, there is nothing to listen on.")
+	}
+
 	p, err := proxy.NewReverseProxy(ctx)
 	if err != nil {
 		logrus.Fatalf("Failed to get reverse proxy: %v", err)
 	}
 
-	handler, err := impersonation.NewAuthnHeaderHandler(ctx, p)
+	sessions := admin.NewSessions()
+	throttled, err := throttle.NewHandler(ctx, sessions.Wrap(p))
+	if err != nil {
+		logrus.Fatalf("Failed to get throttling handler: %v", err)
+	}
+
+	lockouts, err := lockout.NewTracker(ctx)
+	if err != nil {
+		logrus.Fatalf("Failed to get lockout tracker: %v", err)
+	}
+
+	auth := authnprovider.NewAuthnProvider()
+	handler, err := impersonation.NewAuthnHeaderHandler(ctx, throttled, auth, lockouts)
 	if err != nil {
 		logrus.Fatalf("Failed to get impersonation handler: %v", err)
 	}
 
-	conf := config.GetManager(ctx)
+	drainer := server.NewDrainer()
+
+	trustedProxies, err := ipfilter.ParseCIDRs(conf.Get("forwarded.trusted.cidrs"))
+	if err != nil {
+		logrus.Fatalf("Invalid forwarded.trusted.cidrs: %v", err)
+	}
+	forwardedHeader, err := request.ForwardedHeaderName(conf.GetString("forwarded.header", "x-forwarded-for"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	handler = metrics.InstrumentHandler(drainer.Wrap(handler))
+
+	accessLog, err := accesslog.New(ctx)
+	if err != nil {
+		logrus.Fatalf("Failed to set up access log: %v", err)
+	}
+	// frontend wraps the handlers of the frontend servers, so that redirects and health checks are logged too
+	frontend := func(h http.Handler) http.Handler {
+		return request.WithRequestIDHandler(request.WithClientIPHandler(accessLog.Wrap(h), trustedProxies, forwardedHeader))
+	}
+
+	gracePeriod, err := conf.GetDuration("shutdown.grace.period", defaultGracePeriod)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	shutdownDelay, err := conf.GetDuration("shutdown.delay", 0)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	var certStore *certs.Store
+	var frontendTLS *tls.Config
+	if httpsHost != "" {
+		frontendTLS, err = tlspolicy.New(conf, "frontend.tls", true)
+		if err != nil {
+			logrus.Fatalf("Invalid frontend TLS config: %v", err)
+		}
+
+		certStore, err = certs.NewStore(ctx)
+		if err != nil {
+			logrus.Fatalf("Failed to load frontend certificates: %v", err)
+		}
+		if certStore == nil {
+			logrus.Infof("No frontend certificates configured, using bootstrapped self-signed certificates.")
+			certStore, err = certs.Bootstrap(ctx)
+			if err != nil {
+				logrus.Fatalf("Failed to bootstrap frontend certificates: %v", err)
+			}
+			handler = certStore.CAHandler(handler)
+		}
+		frontendTLS.GetCertificate = certStore.GetCertificate
+	}
 
-	if conf.Get("log.level") != "" {
-		l, err := logrus.ParseLevel(conf.Get("log.level"))
-		if err == nil {
-			logrus.SetLevel(l)
+	// Servers exiting for any reason other than shutdown are reported here
+	errs := make(chan error, 3)
+	var frontends []*http.Server
+
+	checks := []health.Checker{
+		health.PingCheck,
+		health.ConfigCheck(conf),
+		health.TokenCheck(conf),
+		health.NamedCheck("backend", p.Ping),
+		health.NamedCheck("authenticator", func(*http.Request) error {
+			return errors.Wrap(authnprovider.Healthy(auth), auth.Name())
+		}),
+	}
+	if certStore != nil {
+		checks = append(checks, health.CertCheck("frontend-cert", certStore.Leaves))
+	}
+	shutdownCheck := health.NamedCheck("shutdown", func(*http.Request) error {
+		if drainer.Draining() {
+			return errors.New("shutting down")
 		}
+		return nil
+	})
+	healthHandlers := map[string]http.Handler{
+		"/healthz": health.Handler("healthz", checks...),
+		"/livez":   health.Handler("livez", health.PingCheck),
+		"/readyz":  health.Handler("readyz", append(checks, shutdownCheck)...),
+	}
+
+	var adminServer *http.Server
+	if conf.GetString("admin.http.host", "") != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", metrics.Handler())
+		for path, h := range healthHandlers {
+			adminMux.Handle(path, h)
+		}
+		adminMux.Handle("/admin/", admin.Handler(&admin.State{
+			Config:         conf,
+			Authenticators: []authnprovider.Authenticator{auth},
+			Backend:        p,
+			Certs:          certStore,
+			Sessions:       sessions,
+			Lockouts:       lockouts,
+		}))
+		adminServer = &http.Server{
+			Handler: adminMux,
+		}
+		serve("admin", adminServer, listen(conf, "admin.http"), errs)
 	}
 
-	httpsHost := conf.Get("frontend.https.host")
 	if httpsHost != "" {
-		go func() {
-			logrus.Infof("Starting https server listening on %v.", httpsHost)
-			err := http.ListenAndServeTLS(httpsHost, conf.Get("frontend.ssl.cert.path"), conf.Get("frontend.ssl.key.path"), handler)
-			logrus.Fatalf("https server exited. Error: %v", err)
-		}()
+		httpsServer := &http.Server{
+			Handler:   frontend(handler),
+			TLSConfig: frontendTLS,
+		}
+		if !tlspolicy.HTTP2(frontendTLS) {
+			// A non-nil map stops net/http from enabling HTTP/2
+			httpsServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+		frontends = append(frontends, httpsServer)
+		serve("https", httpsServer, tls.NewListener(listen(conf, "frontend.https"), frontendTLS), errs)
+	}
+
+	if httpHost != "" {
+		httpHandler, err := httpFrontendHandler(conf, handler, healthHandlers)
+		if err != nil {
+			logrus.Fatalf("Invalid http frontend config: %v", err)
+		}
+		httpServer := &http.Server{
+			Handler: frontend(httpHandler),
+		}
+		frontends = append(frontends, httpServer)
+		serve("http", httpServer, listen(conf, "frontend.http"), errs)
 	}
 
-	httpHost := conf.Get("frontend.http.host")
-	server := &http.Server{
-		Handler: handler,
-		Addr:    httpHost,
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	exitCode := 0
+	select {
+	case sig := <-signals:
+		logrus.Infof("Received %v, shutting down.", sig)
+	case err := <-errs:
+		logrus.Errorf("%v, shutting down.", err)
+		exitCode = 1
+	}
+
+	shutdown(drainer, frontends, adminServer, shutdownDelay, gracePeriod)
+	cancelF()
+	os.Exit(exitCode)
+}
+
+// setLogFormat switches between the text and json formats.
+func setLogFormat(format string) {
+	if format == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	}
+}
+
+func setLogLevel(level string) {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		logrus.Errorf("Invalid log.level: %v", err)
+		return
+	}
+	if l != logrus.GetLevel() {
+		logrus.SetLevel(l)
+		logrus.Infof("Log level set to %v.", l)
+	}
+}
+
+func listen(conf *config.Manager, prefix string) net.Listener {
+	l, err := listener.New(conf, prefix)
+	if err != nil {
+		logrus.Fatalf("Failed to listen on %v: %v", conf.Get(prefix+".host"), err)
+	}
+	return l
+}
+
+// serve runs s on l in the background, reporting its exit on errs unless it was shut down.
+func serve(name string, s *http.Server, l net.Listener, errs chan<- error) {
+	s.Addr = l.Addr().String()
+	go func() {
+		logrus.Infof("Starting %v server listening on %v.", name, s.Addr)
+		if err := s.Serve(l); err != http.ErrServerClosed {
+			errs <- errors.Wrapf(err, "%v server exited", name)
+		}
+	}()
+}
+
+// httpFrontendHandler decides what the cleartext listener serves. Proxying over http would leak Basic Auth
+// credentials and cookies, so unless frontend.http.allow.cleartext is true it only serves the health endpoints and
+// redirects everything else to the https server.
+func httpFrontendHandler(conf *config.Manager, handler http.Handler, healthHandlers map[string]http.Handler) (http.Handler, error) {
+	allowCleartext, err := conf.GetBool("frontend.http.allow.cleartext", false)
+	if err != nil {
+		return nil, err
+	}
+	if allowCleartext {
+		logrus.Warnf("Proxying cleartext http requests, credentials will be sent unencrypted.")
+		return handler, nil
+	}
+
+	httpsHost := conf.GetString("frontend.https.host", "")
+	if httpsHost == "" {
+		return nil, errors.New("frontend.https.host isn't set, so there is nothing to redirect http requests to. " +
+			"Configure https, or set frontend.http.allow.cleartext=true to proxy over http")
+	}
+
+	code, err := conf.GetInt("frontend.http.redirect.code", http.StatusPermanentRedirect)
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusPermanentRedirect && code != http.StatusMovedPermanently {
+		return nil, errors.Errorf("invalid frontend.http.redirect.code %v, expected 301 or 308", code)
+	}
+
+	mux := http.NewServeMux()
+	for path, h := range healthHandlers {
+		mux.Handle(path, h)
+	}
+	redirect, err := server.RedirectHandler(httpsHost, conf.GetString("frontend.http.redirect.host", ""), code)
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle("/", redirect)
+	return mux, nil
+}
+
+// shutdown fails readiness, gives load balancers shutdownDelay to stop sending new connections, then closes the
+// frontend listeners and waits up to gracePeriod for in flight requests. The admin server is stopped last so that
+// metrics and readiness stay available while draining.
+func shutdown(drainer *server.Drainer, frontends []*http.Server, adminServer *http.Server, shutdownDelay, gracePeriod time.Duration) {
+	drainer.Drain()
+	if shutdownDelay > 0 {
+		logrus.Infof("Waiting %v before closing listeners.", shutdownDelay)
+		time.Sleep(shutdownDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	done := make(chan struct{})
+	for _, s := range frontends {
+		go func(s *http.Server) {
+			if err := s.Shutdown(ctx); err != nil {
+				logrus.Warnf("Server on %v didn't shut down cleanly: %v", s.Addr, err)
+			}
+			done <- struct{}{}
+		}(s)
+	}
+	for range frontends {
+		<-done
+	}
+
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			logrus.Warnf("Admin server didn't shut down cleanly: %v", err)
+		}
 	}
-	logrus.Infof("Starting http server listening on %v.", httpHost)
-	err = server.ListenAndServe()
-	logrus.Infof("https server exited. Error: %v", err)
+	logrus.Infof("Shutdown complete.")
 }
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rancher/authn-proxy/request"
+	"github.com/rancher/authn-proxy/responsewriters"
+)
+
+// knownVerbs are the verb label values, the Kubernetes verbs and the HTTP methods of non resource requests. Any other
+// method is counted as "other", so clients can't create label values at will.
+var knownVerbs = map[string]bool{
+	"get": true, "list": true, "watch": true, "create": true, "update": true, "patch": true, "delete": true,
+	"deletecollection": true, "post": true, "put": true, "head": true, "options": true,
+}
+
+// knownResources are the resource label values. Authenticated users can still request any path, so other resources
+// are counted as "other" to keep the number of series bounded.
+var knownResources = map[string]bool{
+	"bindings": true, "componentstatuses": true, "configmaps": true, "endpoints": true, "events": true,
+	"limitranges": true, "namespaces": true, "nodes": true, "persistentvolumeclaims": true, "persistentvolumes": true,
+	"pods": true, "podtemplates": true, "replicationcontrollers": true, "resourcequotas": true, "secrets": true,
+	"serviceaccounts": true, "services": true, "mutatingwebhookconfigurations": true,
+	"validatingwebhookconfigurations": true, "customresourcedefinitions": true, "apiservices": true,
+	"controllerrevisions": true, "daemonsets": true, "deployments": true, "replicasets": true, "statefulsets": true,
+	"tokenreviews": true, "localsubjectaccessreviews": true, "selfsubjectaccessreviews": true,
+	"selfsubjectrulesreviews": true, "subjectaccessreviews": true, "horizontalpodautoscalers": true,
+	"cronjobs": true, "jobs": true, "certificatesigningrequests": true, "leases": true, "ingresses": true,
+	"networkpolicies": true, "poddisruptionbudgets": true, "podsecuritypolicies": true, "clusterrolebindings": true,
+	"clusterroles": true, "rolebindings": true, "roles": true, "priorityclasses": true, "storageclasses": true,
+	"volumeattachments": true,
+}
+
+// InstrumentHandler records request counts, latencies and the number of in flight requests for next. The resource
+// label is only set for authenticated requests, since anyone can make up resource paths.
+func InstrumentHandler(next http.Handler) http.Handler {
+	inFlight := InFlightRequests.WithLabelValues()
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		info := request.NewInfo(req)
+		req, record := request.WithRecord(req)
+		w := responsewriters.Wrap(rw)
+		defer func() {
+			status := w.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			code := strconv.Itoa(status)
+			verb, resource := info.Verb, ""
+			if !knownVerbs[verb] {
+				verb = "other"
+			}
+			if _, ok := record.User(); ok {
+				resource = info.Resource
+				if resource != "" && !knownResources[resource] {
+					resource = "other"
+				}
+			}
+			Requests.WithLabelValues(code, verb, resource).Inc()
+			RequestLatency.WithLabelValues(code, verb, resource).Observe(time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// InstrumentRoundTripper counts requests to the backend that fail without a response. Requests canceled by the
+// client aren't backend errors and aren't counted.
+func InstrumentRoundTripper(next http.RoundTripper) http.RoundTripper {
+	errs := BackendErrors.WithLabelValues()
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		if err != nil && err != context.Canceled && req.Context().Err() != context.Canceled {
+			errs.Inc()
+		}
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
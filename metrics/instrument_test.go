@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/authn-proxy/request"
+)
+
+func TestInstrumentHandlerLabels(t *testing.T) {
+	defer func(saved *CounterVec) { Requests = saved }(Requests)
+	defer newTestRegistry(t)()
+	Requests = NewCounterVec("test_requests_total", "Requests.", "code", "verb", "resource")
+
+	h := InstrumentHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "" {
+			request.WithUser(req.Context(), &request.User{Name: "alice"})
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	for _, r := range []struct {
+		method, path string
+		authn        bool
+	}{
+		{"GET", "/api/v1/namespaces/default/pods", true},
+		{"GET", "/api/v1/namespaces/default/madeup", true},
+		{"GET", "/api/v1/namespaces/default/pods", false},
+		{"BREW", "/api/v1/pods", true},
+	} {
+		req := httptest.NewRequest(r.method, r.path, nil)
+		if r.authn {
+			req.Header.Set("Authorization", "Basic x")
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	labels, values := Requests.sorted()
+	got := map[string]float64{}
+	for i := range values {
+		got[strings.Join(labels[i], ",")] = values[i].(*value).get()
+	}
+	want := map[string]float64{
+		"204,list,pods":  1,
+		"204,list,other": 1,
+		"204,list,":      1,
+		"204,other,pods": 1,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got series %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("series %q is %v, want %v (all: %v)", k, got[k], v, got)
+		}
+	}
+}
+
+func TestInstrumentRoundTripperIgnoresCanceledRequests(t *testing.T) {
+	defer func(saved *CounterVec) { BackendErrors = saved }(BackendErrors)
+	defer newTestRegistry(t)()
+	BackendErrors = NewCounterVec("test_backend_errors_total", "Errors.")
+
+	rt := InstrumentRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if err := req.Context().Err(); err != nil {
+			return nil, errors.New("net/http: request canceled")
+		}
+		return nil, errors.New("connection refused")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rt.RoundTrip(httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if v := BackendErrors.WithLabelValues().v.get(); v != 0 {
+		t.Fatalf("canceled request counted as a backend error")
+	}
+	rt.RoundTrip(httptest.NewRequest("GET", "/", nil))
+	if v := BackendErrors.WithLabelValues().v.get(); v != 1 {
+		t.Fatalf("backend errors is %v, expected 1", v)
+	}
+}
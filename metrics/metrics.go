@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"crypto/x509"
+)
+
+var (
+	Requests = NewCounterVec("authn_proxy_requests_total",
+		"Number of requests handled, partitioned by status code, verb and resource.",
+		"code", "verb", "resource")
+	RequestLatency = NewHistogramVec("authn_proxy_request_duration_seconds",
+		"Request latency in seconds, partitioned by status code, verb and resource.",
+		DefBuckets, "code", "verb", "resource")
+	InFlightRequests = NewGaugeVec("authn_proxy_inflight_requests",
+		"Number of requests currently being handled.")
+	AuthenticationAttempts = NewCounterVec("authn_proxy_authentication_attempts_total",
+		"Number of authentication attempts, partitioned by provider and result.",
+		"provider", "result")
+	ThrottledRequests = NewCounterVec("authn_proxy_throttled_requests_total",
+		"Number of requests rejected with 429, partitioned by the limit that was hit.",
+		"limit")
+	Lockouts = NewCounterVec("authn_proxy_lockouts_total",
+		"Number of times a username or client IP was locked out after failed authentications.",
+		"kind")
+	ActiveLockouts = NewGaugeVec("authn_proxy_active_lockouts",
+		"Number of usernames or client IPs currently locked out.",
+		"kind")
+	IPFilterRejections = NewCounterVec("authn_proxy_ipfilter_rejections_total",
+		"Number of requests rejected by client IP allow and deny lists, partitioned by whether a global or a user or group list rejected it.",
+		"scope")
+	BackendErrors = NewCounterVec("authn_proxy_backend_errors_total",
+		"Number of requests that failed to reach the backend.")
+	ConfigReloads = NewCounterVec("authn_proxy_config_reloads_total",
+		"Number of times a config file was reloaded after a change.",
+		"file")
+	ConfigReloadFailures = NewCounterVec("authn_proxy_config_reload_failures_total",
+		"Number of times reloading a config file failed.",
+		"file")
+	TLSCertExpiry = NewGaugeVec("authn_proxy_tls_cert_expiry_timestamp_seconds",
+		"Expiry time of loaded TLS certificates, as a unix timestamp.",
+		"use", "path")
+	TLSCertReloads = NewCounterVec("authn_proxy_tls_cert_reloads_total",
+		"Number of times a changed TLS certificate was reloaded, partitioned by result.",
+		"use", "result")
+)
+
+// SetCertExpiry records the earliest expiry among certs, which is when the chain stops being usable.
+func SetCertExpiry(use, path string, certs []*x509.Certificate) {
+	if len(certs) == 0 {
+		return
+	}
+	earliest := certs[0].NotAfter
+	for _, c := range certs[1:] {
+		if c.NotAfter.Before(earliest) {
+			earliest = c.NotAfter
+		}
+	}
+	TLSCertExpiry.WithLabelValues(use, path).Set(float64(earliest.Unix()))
+}
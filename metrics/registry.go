@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A minimal implementation of the Prometheus text exposition format. The proxy only needs counters, gauges and
+// histograms with labels, which doesn't justify vendoring the full prometheus client and its dependency tree.
+
+type collector interface {
+	name() string
+	write(buf *bytes.Buffer)
+}
+
+type registry struct {
+	m          sync.Mutex
+	collectors map[string]collector
+}
+
+var defaultRegistry = &registry{
+	collectors: map[string]collector{},
+}
+
+func (r *registry) register(c collector) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.collectors[c.name()]; ok {
+		panic(fmt.Sprintf("metric %v registered twice", c.name()))
+	}
+	r.collectors[c.name()] = c
+}
+
+func (r *registry) write(buf *bytes.Buffer) {
+	r.m.Lock()
+	var names []string
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := r.collectors
+	r.m.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		collectors[name].write(buf)
+	}
+}
+
+// Handler serves all registered metrics in the Prometheus text exposition format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		buf := &bytes.Buffer{}
+		defaultRegistry.write(buf)
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		rw.Write(buf.Bytes())
+	})
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, kind string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(buf *bytes.Buffer, name string, labelNames, labelValues []string, value float64) {
+	buf.WriteString(name)
+	if len(labelNames) > 0 {
+		buf.WriteByte('{')
+		for i, l := range labelNames {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%s=\"%s\"", l, escapeLabelValue(labelValues[i]))
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+var labelValueReplacer = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// newTestRegistry swaps in an empty default registry, so tests can register metrics without clashing with the
+// proxy's own.
+func newTestRegistry(t *testing.T) func() {
+	saved := defaultRegistry
+	defaultRegistry = &registry{collectors: map[string]collector{}}
+	return func() { defaultRegistry = saved }
+}
+
+func exposition() string {
+	buf := &bytes.Buffer{}
+	defaultRegistry.write(buf)
+	return buf.String()
+}
+
+func TestCounterAndGaugeExposition(t *testing.T) {
+	defer newTestRegistry(t)()
+
+	c := NewCounterVec("test_total", "A counter.\nWith \\ in help.", "code", "verb")
+	c.WithLabelValues("200", "get").Inc()
+	c.WithLabelValues("200", "get").Add(2)
+	c.WithLabelValues("500", "list").Inc()
+	g := NewGaugeVec("test_gauge", "A gauge.")
+	g.WithLabelValues().Set(1.5)
+	g.WithLabelValues().Dec()
+	inf := NewGaugeVec("test_inf", "Special values.", "kind")
+	inf.WithLabelValues("pos").Set(math.Inf(1))
+	inf.WithLabelValues("neg").Set(math.Inf(-1))
+	inf.WithLabelValues("nan").Set(math.NaN())
+
+	want := `# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 0.5
+# HELP test_inf Special values.
+# TYPE test_inf gauge
+test_inf{kind="nan"} NaN
+test_inf{kind="neg"} -Inf
+test_inf{kind="pos"} +Inf
+# HELP test_total A counter.\nWith \\ in help.
+# TYPE test_total counter
+test_total{code="200",verb="get"} 3
+test_total{code="500",verb="list"} 1
+`
+	if got := exposition(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	defer newTestRegistry(t)()
+
+	h := NewHistogramVec("test_seconds", "A histogram.", []float64{1, 0.5}, "code")
+	h.WithLabelValues("200").Observe(0.25)
+	h.WithLabelValues("200").Observe(0.75)
+	h.WithLabelValues("200").Observe(2)
+
+	want := `# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{code="200",le="0.5"} 1
+test_seconds_bucket{code="200",le="1"} 2
+test_seconds_bucket{code="200",le="+Inf"} 3
+test_seconds_sum{code="200"} 3
+test_seconds_count{code="200"} 3
+`
+	if got := exposition(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	defer newTestRegistry(t)()
+
+	c := NewCounterVec("test_total", "A counter.", "a", "b")
+	c.WithLabelValues("quote \" backslash \\ newline \n", "x").Inc()
+	// Invalid UTF-8, including the byte used to separate label values in keys, is replaced
+	c.WithLabelValues("bad \xff", "\xfe").Inc()
+	c.WithLabelValues("bad \xfd", "\xfc").Inc()
+	g := NewGaugeVec("test_gauge", "A gauge.", "a")
+	g.WithLabelValues("x").Set(1)
+	g.Delete("x")
+
+	want := `# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+# HELP test_total A counter.
+# TYPE test_total counter
+test_total{a="bad �",b="�"} 2
+test_total{a="quote \" backslash \\ newline \n",b="x"} 1
+`
+	if got := exposition(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	defer newTestRegistry(t)()
+
+	c := NewCounterVec("test_total", "A counter.", "a")
+	for name, f := range map[string]func(){
+		"duplicate name":     func() { NewCounterVec("test_total", "Again.") },
+		"wrong label count":  func() { c.WithLabelValues("x", "y") },
+		"decreasing counter": func() { c.WithLabelValues("x").Add(-1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected a panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
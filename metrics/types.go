@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default latency buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// labeledValues holds one value per distinct set of label values. The key is the label values joined by a
+// separator that can't appear in valid UTF-8. The exposition format requires UTF-8, so invalid bytes in label values
+// are replaced, which also keeps the separator out of them.
+type labeledValues struct {
+	m          sync.Mutex
+	metricName string
+	help       string
+	labelNames []string
+	values     map[string]interface{}
+}
+
+const labelSep = "\xff"
+
+func newLabeledValues(name, help string, labelNames []string) labeledValues {
+	return labeledValues{
+		metricName: name,
+		help:       help,
+		labelNames: labelNames,
+		values:     map[string]interface{}{},
+	}
+}
+
+func (l *labeledValues) name() string {
+	return l.metricName
+}
+
+func (l *labeledValues) get(labelValues []string, create func() interface{}) interface{} {
+	if len(labelValues) != len(l.labelNames) {
+		panic(fmt.Sprintf("metric %v expects %d label values, got %d", l.metricName, len(l.labelNames), len(labelValues)))
+	}
+	key := labelKey(labelValues)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	v, ok := l.values[key]
+	if !ok {
+		v = create()
+		l.values[key] = v
+	}
+	return v
+}
+
+func (l *labeledValues) delete(labelValues []string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	delete(l.values, labelKey(labelValues))
+}
+
+func labelKey(labelValues []string) string {
+	valid := make([]string, len(labelValues))
+	for i, v := range labelValues {
+		valid[i] = strings.ToValidUTF8(v, "\uFFFD")
+	}
+	return strings.Join(valid, labelSep)
+}
+
+// sorted returns a snapshot of the label value keys and their values, ordered for stable output.
+func (l *labeledValues) sorted() ([][]string, []interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	var keys []string
+	for k := range l.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	labels := make([][]string, len(keys))
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		if len(l.labelNames) > 0 {
+			labels[i] = strings.Split(k, labelSep)
+		}
+		values[i] = l.values[k]
+	}
+	return labels, values
+}
+
+type value struct {
+	m sync.Mutex
+	v float64
+}
+
+func (v *value) add(delta float64) {
+	v.m.Lock()
+	v.v += delta
+	v.m.Unlock()
+}
+
+func (v *value) set(val float64) {
+	v.m.Lock()
+	v.v = val
+	v.m.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.m.Lock()
+	defer v.m.Unlock()
+	return v.v
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v *value
+}
+
+func (c Counter) Inc() {
+	c.v.add(1)
+}
+
+func (c Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("counter cannot decrease in value")
+	}
+	c.v.add(delta)
+}
+
+type CounterVec struct {
+	labeledValues
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newLabeledValues(name, help, labelNames)}
+	defaultRegistry.register(c)
+	return c
+}
+
+func (c *CounterVec) WithLabelValues(labelValues ...string) Counter {
+	return Counter{c.get(labelValues, func() interface{} { return &value{} }).(*value)}
+}
+
+func (c *CounterVec) write(buf *bytes.Buffer) {
+	writeHeader(buf, c.metricName, c.help, "counter")
+	labels, values := c.sorted()
+	for i := range values {
+		writeSample(buf, c.metricName, c.labelNames, labels[i], values[i].(*value).get())
+	}
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v *value
+}
+
+func (g Gauge) Set(val float64) {
+	g.v.set(val)
+}
+
+func (g Gauge) Inc() {
+	g.v.add(1)
+}
+
+func (g Gauge) Dec() {
+	g.v.add(-1)
+}
+
+func (g Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+type GaugeVec struct {
+	labeledValues
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{newLabeledValues(name, help, labelNames)}
+	defaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeVec) WithLabelValues(labelValues ...string) Gauge {
+	return Gauge{g.get(labelValues, func() interface{} { return &value{} }).(*value)}
+}
+
+// Delete removes the series with the given label values, if present.
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.delete(labelValues)
+}
+
+func (g *GaugeVec) write(buf *bytes.Buffer) {
+	writeHeader(buf, g.metricName, g.help, "gauge")
+	labels, values := g.sorted()
+	for i := range values {
+		writeSample(buf, g.metricName, g.labelNames, labels[i], values[i].(*value).get())
+	}
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	h *histogramValue
+}
+
+type histogramValue struct {
+	m       sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h Histogram) Observe(v float64) {
+	h.h.m.Lock()
+	defer h.h.m.Unlock()
+	for i, upper := range h.h.buckets {
+		if v <= upper {
+			h.h.counts[i]++
+		}
+	}
+	h.h.sum += v
+	h.h.count++
+}
+
+type HistogramVec struct {
+	labeledValues
+	buckets []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	h := &HistogramVec{
+		labeledValues: newLabeledValues(name, help, labelNames),
+		buckets:       b,
+	}
+	defaultRegistry.register(h)
+	return h
+}
+
+func (h *HistogramVec) WithLabelValues(labelValues ...string) Histogram {
+	return Histogram{h.get(labelValues, func() interface{} {
+		return &histogramValue{
+			buckets: h.buckets,
+			counts:  make([]uint64, len(h.buckets)),
+		}
+	}).(*histogramValue)}
+}
+
+func (h *HistogramVec) write(buf *bytes.Buffer) {
+	writeHeader(buf, h.metricName, h.help, "histogram")
+	labelNames := append(append([]string{}, h.labelNames...), "le")
+	labels, values := h.sorted()
+	for i := range values {
+		hv := values[i].(*histogramValue)
+		hv.m.Lock()
+		for j, upper := range hv.buckets {
+			writeSample(buf, h.metricName+"_bucket", labelNames, append(append([]string{}, labels[i]...), formatFloat(upper)), float64(hv.counts[j]))
+		}
+		writeSample(buf, h.metricName+"_bucket", labelNames, append(append([]string{}, labels[i]...), formatFloat(math.Inf(1))), float64(hv.count))
+		writeSample(buf, h.metricName+"_sum", h.labelNames, labels[i], hv.sum)
+		writeSample(buf, h.metricName+"_count", h.labelNames, labels[i], float64(hv.count))
+		hv.m.Unlock()
+	}
+}
@@ -1,98 +1,322 @@
 package proxy
 
 import (
-	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"context"
 
 	"github.com/pkg/errors"
 	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/metrics"
+	"github.com/rancher/authn-proxy/request"
+	"github.com/rancher/authn-proxy/tlspolicy"
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/cert"
 )
 
 const (
-	configPath = "/var/run/cattle.io/config/server.properties"
+	DefaultConfigPath = "/var/run/cattle.io/config/server.properties"
 )
 
-func NewReverseProxy(ctx context.Context) (http.Handler, error) {
-	c := config.GetManager(ctx)
+const (
+	pingTimeout = 5 * time.Second
+)
 
-	cPath := os.Getenv("CONFIG_PATH")
-	if cPath == "" {
-		cPath = configPath
-	}
-	if err := c.AddConfigFile(cPath, config.PropertiesFile); err != nil {
-		return nil, errors.Wrapf(err, "couldn't add config file %v", cPath)
+// ReverseProxy forwards requests to the backend. The backend is reconfigured when its config keys or CA file
+// change, without dropping requests in flight.
+type ReverseProxy struct {
+	*httputil.ReverseProxy
+	c *config.Manager
+
+	m          sync.RWMutex
+	backend    backend
+	watchedCAs map[string]bool
+	// reloadM serializes reloads, which both config changes and CA file changes trigger
+	reloadM sync.Mutex
+}
+
+type backend struct {
+	scheme    string
+	host      string
+	caPath    string
+	transport *http.Transport
+}
+
+// backendKey is the context key of the backend a request is sent to
+type backendKey struct{}
+
+// backendKeys are the keys that configure the backend
+var backendKeys = []string{
+	"backend.scheme",
+	"backend.host",
+	"backend.ca.cert.path",
+	"backend.tls.profile",
+	"backend.tls.min.version",
+	"backend.tls.cipher.suites",
+	"backend.tls.curves",
+	"backend.tls.http2",
+}
+
+// LoadConfig adds the proxy's config file to c.
+func LoadConfig(c *config.Manager, configPath string) error {
+	if err := c.AddConfigFile(configPath, config.ParseTypeFor(configPath)); err != nil {
+		return errors.Wrapf(err, "couldn't add config file %v", configPath)
 	}
+	return nil
+}
 
-	backendScheme, backendHost, transport, err := getBackendConfig(c)
+func NewReverseProxy(ctx context.Context) (*ReverseProxy, error) {
+	c := config.GetManager(ctx)
+
+	b, err := getBackendConfig(c)
 	if err != nil {
 		return nil, errors.Wrap(err, "error determining backend")
 	}
-	logrus.Infof("Using backend scheme: %v, backendHost: %v", backendScheme, backendHost)
+	logrus.Infof("Using backend scheme: %v, backendHost: %v", b.scheme, b.host)
+
+	p := &ReverseProxy{
+		c:          c,
+		backend:    b,
+		watchedCAs: map[string]bool{},
+	}
 
 	director := func(req *http.Request) {
-		req.URL.Scheme = backendScheme
-		req.URL.Host = backendHost
+		b := p.backendFor(req)
+		req.URL.Scheme = b.scheme
+		req.URL.Host = b.host
+		request.SetBackend(req.Context(), b.host)
 	}
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := p.backendFor(req).transport.RoundTrip(req)
+		if err != nil && req.Context().Err() == nil {
+			request.Log(req).Errorf("Error proxying %v %v to the backend: %v", req.Method, request.RedactURL(req.URL), err)
+		}
+		return resp, err
+	})
 
-	reverseProxy := &httputil.ReverseProxy{
+	p.ReverseProxy = &httputil.ReverseProxy{
 		Director:      director,
 		FlushInterval: time.Millisecond * 100,
-		Transport:     transport,
+		Transport:     metrics.InstrumentRoundTripper(transport),
+		// Backend errors are logged by the transport with the request's fields, so only keep the rest
+		ErrorLog: log.New(backendErrorLog{}, "", 0),
+	}
+
+	c.Watch(func([]config.Change) { p.reload() }, backendKeys...)
+	p.watchCA(b.caPath)
+	return p, nil
+}
+
+// ServeHTTP picks the backend for the request before proxying it, so that the transport used is the one of the
+// backend the URL was built for, even if the backend is reloaded in between.
+func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	ctx := context.WithValue(req.Context(), backendKey{}, p.current())
+	p.ReverseProxy.ServeHTTP(rw, req.WithContext(ctx))
+}
+
+// backendFor returns the backend picked for req by ServeHTTP.
+func (p *ReverseProxy) backendFor(req *http.Request) backend {
+	if b, ok := req.Context().Value(backendKey{}).(backend); ok {
+		return b
+	}
+	return p.current()
+}
+
+func (p *ReverseProxy) current() backend {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.backend
+}
+
+// reload switches to the currently configured backend. If the new config is invalid the previous backend is kept.
+func (p *ReverseProxy) reload() {
+	p.reloadM.Lock()
+	defer p.reloadM.Unlock()
+
+	b, err := getBackendConfig(p.c)
+	if err != nil {
+		logrus.Errorf("Couldn't reconfigure backend, keeping the previous config: %v", err)
+		return
+	}
+
+	p.m.Lock()
+	old := p.backend
+	p.backend = b
+	p.m.Unlock()
+
+	old.transport.CloseIdleConnections()
+	logrus.Infof("Reconfigured backend scheme: %v, backendHost: %v", b.scheme, b.host)
+	p.watchCA(b.caPath)
+}
+
+// watchCA reloads the backend when the CA file is rotated.
+func (p *ReverseProxy) watchCA(path string) {
+	if path == "" {
+		return
 	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.watchedCAs[path] {
+		return
+	}
+	if err := p.c.WatchFile(path, func() {
+		if p.current().caPath == path {
+			p.reload()
+		}
+	}); err != nil {
+		logrus.Warnf("Couldn't watch backend CA %v, changes to it won't be picked up: %v", path, err)
+		return
+	}
+	p.watchedCAs[path] = true
+}
+
+// BackendInfo describes the backend requests are sent to.
+type BackendInfo struct {
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"`
+	CAPath string `json:"caPath,omitempty"`
+}
+
+// Backend returns the backend requests are currently sent to.
+func (p *ReverseProxy) Backend() BackendInfo {
+	b := p.current()
+	return BackendInfo{
+		Scheme: b.scheme,
+		Host:   b.host,
+		CAPath: b.caPath,
+	}
+}
+
+// backendErrorLog drops the reverse proxy's own reports of backend errors, which the transport has already logged,
+// and passes anything else to logrus.
+type backendErrorLog struct{}
+
+func (backendErrorLog) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	if !strings.HasPrefix(msg, "http: proxy error:") {
+		logrus.Warn(msg)
+	}
+	return len(p), nil
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Ping checks that the backend can be reached. Any HTTP response will do, since the proxy's token isn't
+// necessarily allowed to read /healthz.
+func (p *ReverseProxy) Ping(req *http.Request) error {
+	b := p.current()
+	client := &http.Client{
+		Transport: b.transport,
+		Timeout:   pingTimeout,
+	}
+	resp, err := client.Get(fmt.Sprintf("%s://%s/healthz", b.scheme, b.host))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
 
-	return reverseProxy, nil
+// CheckConfig checks the backend CA bundle and TLS config without connecting to the backend.
+func CheckConfig(c *config.Manager) error {
+	if _, err := tlspolicy.New(c, "backend.tls", true); err != nil {
+		return errors.Wrap(err, "invalid backend TLS config")
+	}
+	path, err := c.GetFilePath("backend.ca.cert.path")
+	if err != nil || path == "" {
+		return err
+	}
+	caCert, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "problem reading ca cert file %v", path)
+	}
+	if _, err := cert.ParseCertsPEM(caCert); err != nil {
+		return errors.Wrapf(err, "invalid ca cert file %v", path)
+	}
+	return nil
 }
 
-func getBackendConfig(c *config.Manager) (string, string, http.RoundTripper, error) {
-	scheme := c.Get("backend.scheme")
-	host := c.Get("backend.host")
+func getBackendConfig(c *config.Manager) (backend, error) {
+	scheme := c.GetString("backend.scheme", "")
+	host := c.GetString("backend.host", "")
 	caCertPath := ""
 	if scheme == "" || host == "" {
 		logrus.Infof("config properties backend.host or backend.scheme. Assuming in-cluster configuration")
 		kubeConfig, err := rest.InClusterConfig()
 		if err != nil {
-			return "", "", nil, err
+			return backend{}, err
 		}
 
 		// For scheme and host
 		u, err := url.Parse(kubeConfig.Host)
 		if err != nil {
-			return "", "", nil, errors.Wrap(err, "problem parsing kubeconfig url")
+			return backend{}, errors.Wrap(err, "problem parsing kubeconfig url")
 		}
 		scheme = u.Scheme
 		host = u.Host
 		caCertPath = kubeConfig.CAFile
 	}
 
-	if caCertPath == "" && c.Get("backend.ca.cert.path") != "" {
-		caCertPath = c.Get("backend.ca.cert.path")
-	}
-
 	if caCertPath == "" {
-		return scheme, host, http.DefaultTransport, nil
+		path, err := c.GetFilePath("backend.ca.cert.path")
+		if err != nil {
+			return backend{}, err
+		}
+		caCertPath = path
 	}
 
-	caCert, err := ioutil.ReadFile(caCertPath)
+	tlsConfig, err := tlspolicy.New(c, "backend.tls", true)
 	if err != nil {
-		return "", "", nil, errors.Wrapf(err, "problem reading ca cert file %v", caCert)
+		return backend{}, errors.Wrap(err, "invalid backend TLS config")
+	}
+
+	if caCertPath != "" {
+		caCert, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return backend{}, errors.Wrapf(err, "problem reading ca cert file %v", caCertPath)
+		}
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		if certs, err := cert.ParseCertsPEM(caCert); err == nil {
+			metrics.SetCertExpiry("backend-ca", caCertPath, certs)
+		}
+		tlsConfig.RootCAs = pool
 	}
 
-	pool := x509.NewCertPool()
-	pool.AppendCertsFromPEM(caCert)
+	// Same settings as http.DefaultTransport, which also attempts HTTP/2 unless it's disabled in the TLS policy
 	t := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			RootCAs: pool,
-		},
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     tlspolicy.HTTP2(tlsConfig),
 	}
-	return scheme, host, t, nil
+	return backend{
+		scheme:    scheme,
+		host:      host,
+		caPath:    caCertPath,
+		transport: t,
+	}, nil
 }
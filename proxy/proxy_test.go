@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/request"
+)
+
+// newBackend starts a backend that answers with its own host.
+func newBackend() (*httptest.Server, string) {
+	var host string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(host))
+	}))
+	u, _ := url.Parse(s.URL)
+	host = u.Host
+	return s, host
+}
+
+func TestBackendStaysConsistentAcrossReloads(t *testing.T) {
+	a, hostA := newBackend()
+	defer a.Close()
+	b, hostB := newBackend()
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := config.GetManager(ctx)
+	setHost := func(host string) {
+		c.SetSource("test", config.FlagPriority, map[string]string{"backend.scheme": "http", "backend.host": host})
+	}
+	setHost(hostA)
+	p, err := NewReverseProxy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The backend a request's URL is built for is the one its transport connects to, while reloads run
+	// concurrently from config changes and direct calls
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if i%2 == 0 {
+				setHost(hostB)
+			} else {
+				setHost(hostA)
+			}
+			p.reload()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		req, record := request.WithRecord(httptest.NewRequest("GET", "/api", nil))
+		rw := httptest.NewRecorder()
+		p.ServeHTTP(rw, req)
+		body, _ := ioutil.ReadAll(rw.Body)
+		if rw.Code != http.StatusOK || string(body) != record.Backend() {
+			t.Fatalf("request recorded backend %v but was answered by %q with status %v", record.Backend(), body, rw.Code)
+		}
+	}
+	wg.Wait()
+
+	setHost(hostB)
+	p.reload()
+	if info := p.Backend(); info.Host != hostB {
+		t.Fatalf("backend is %v after reload, expected %v", info.Host, hostB)
+	}
+}
+
+func TestBackendAttemptsHTTP2(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := config.GetManager(ctx)
+
+	c.SetSource("test", config.FlagPriority, map[string]string{"backend.scheme": "https", "backend.host": "a:6443"})
+	b, err := getBackendConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.transport.ForceAttemptHTTP2 {
+		t.Fatal("HTTP/2 to the backend isn't attempted by default")
+	}
+
+	c.SetSource("test", config.FlagPriority, map[string]string{"backend.scheme": "https", "backend.host": "a:6443", "backend.tls.http2": "false"})
+	if b, err = getBackendConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if b.transport.ForceAttemptHTTP2 {
+		t.Fatal("HTTP/2 to the backend is attempted with backend.tls.http2=false")
+	}
+}
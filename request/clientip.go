@@ -0,0 +1,121 @@
+package request
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	XForwardedForHeader = "X-Forwarded-For"
+	ForwardedHeader     = "Forwarded"
+)
+
+// ForwardedHeaderName returns the header named by the forwarded.header config value, x-forwarded-for or forwarded.
+func ForwardedHeaderName(value string) (string, error) {
+	switch value {
+	case "x-forwarded-for":
+		return XForwardedForHeader, nil
+	case "forwarded":
+		return ForwardedHeader, nil
+	}
+	return "", errors.Errorf("invalid forwarded.header %q, expected x-forwarded-for or forwarded", value)
+}
+
+// WithClientIPHandler puts the client IP in the request context. The listeners already report the address from
+// PROXY protocol headers as the connection's remote address. Beyond that, header, X-Forwarded-For or Forwarded, is
+// honored only when the request came from one of trustedProxies: the hops it lists are walked from the nearest, and
+// the first address that isn't a trusted proxy is the client. Only the header the trusted proxies write is read,
+// since they pass the other one on from the client unchanged.
+func WithClientIPHandler(next http.Handler, trustedProxies []*net.IPNet, header string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ip := resolveClientIP(req, trustedProxies, header)
+		next.ServeHTTP(rw, req.WithContext(WithClientIP(req.Context(), ip)))
+	})
+}
+
+// ClientIP returns the client IP from the request context, falling back to the connection's remote address.
+func ClientIP(req *http.Request) string {
+	if ip, ok := ClientIPFrom(req.Context()); ok {
+		return ip
+	}
+	return remoteIP(req)
+}
+
+func resolveClientIP(req *http.Request, trustedProxies []*net.IPNet, header string) string {
+	ip := remoteIP(req)
+	if len(trustedProxies) == 0 || !isTrusted(ip, trustedProxies) {
+		return ip
+	}
+
+	hops := forwardedFor(req, header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		if hop == nil {
+			// Obfuscated or unknown, so nothing before it can be trusted either
+			break
+		}
+		ip = hop.String()
+		if !inNets(trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrusted(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && inNets(trustedProxies, parsed)
+}
+
+func inNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor lists the client addresses recorded by proxies in header, farthest first. The Forwarded header is
+// parsed as in RFC 7239.
+func forwardedFor(req *http.Request, header string) []string {
+	var hops []string
+	if header == ForwardedHeader {
+		for _, element := range strings.Split(strings.Join(req.Header[ForwardedHeader], ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hops = append(hops, stripPort(strings.Trim(kv[1], `"`)))
+				}
+			}
+		}
+		return hops
+	}
+
+	for _, hop := range strings.Split(strings.Join(req.Header[XForwardedForHeader], ","), ",") {
+		if hop = strings.TrimSpace(hop); hop != "" {
+			hops = append(hops, stripPort(hop))
+		}
+	}
+	return hops
+}
+
+// stripPort removes the port from forms like 192.0.2.1:4711 and [2001:db8::1]:4711, and the brackets from
+// [2001:db8::1].
+func stripPort(hop string) string {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+}
+
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,91 @@
+package request
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/24")
+	trusted := []*net.IPNet{proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "untrusted peer's header is ignored",
+			remoteAddr: "192.0.2.1:1234",
+			header:     XForwardedForHeader,
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "nearest untrusted hop is the client",
+			remoteAddr: "10.0.0.1:1234",
+			header:     XForwardedForHeader,
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Forwarded sent by the client is ignored when the proxy writes X-Forwarded-For",
+			remoteAddr: "10.0.0.1:1234",
+			header:     XForwardedForHeader,
+			headers: map[string]string{
+				"Forwarded":       "for=203.0.113.9",
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:       "X-Forwarded-For sent by the client is ignored when the proxy writes Forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			header:     ForwardedHeader,
+			headers: map[string]string{
+				"Forwarded":       `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`,
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:       "no header from a trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			header:     ForwardedHeader,
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "obfuscated hop stops the walk",
+			remoteAddr: "10.0.0.1:1234",
+			header:     ForwardedHeader,
+			headers:    map[string]string{"Forwarded": "for=203.0.113.9, for=_hidden, for=10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		if got := resolveClientIP(req, trusted, tt.header); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestForwardedHeaderName(t *testing.T) {
+	for value, want := range map[string]string{"x-forwarded-for": XForwardedForHeader, "forwarded": ForwardedHeader} {
+		if got, err := ForwardedHeaderName(value); err != nil || got != want {
+			t.Errorf("%v: got %v, %v", value, got, err)
+		}
+	}
+	if _, err := ForwardedHeaderName("x-real-ip"); err == nil {
+		t.Error("expected an error for an unsupported header")
+	}
+}
@@ -0,0 +1,55 @@
+package request
+
+import (
+	"context"
+)
+
+type key int
+
+const (
+	userKey key = iota
+	clientIPKey
+	requestIDKey
+	recordKey
+)
+
+// User is the identity the proxy authenticated the request as.
+type User struct {
+	Name   string
+	Groups []string
+}
+
+// WithUser records the authenticated user, also in the request's Record if it has one.
+func WithUser(ctx context.Context, user *User) context.Context {
+	if r, ok := RecordFrom(ctx); ok {
+		r.m.Lock()
+		r.user = user
+		r.m.Unlock()
+	}
+	return context.WithValue(ctx, userKey, user)
+}
+
+func UserFrom(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userKey).(*User)
+	return user, ok
+}
+
+// WithClientIP records the address of the client that originated the request, which may differ from
+// req.RemoteAddr when the request passed through a load balancer.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+func ClientIPFrom(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey).(string)
+	return ip, ok
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok
+}
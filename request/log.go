@@ -0,0 +1,73 @@
+package request
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redacted replaces secrets in logged request data
+const redacted = "<redacted>"
+
+// sensitiveHeaders carry credentials, and are redacted from logged headers
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// sensitiveParams are query parameters redacted from logged URLs
+var sensitiveParams = []string{"password", "token", "access_token", "secret"}
+
+// Log returns a logger for messages about req, with the request ID and the authenticated user as fields so that
+// every line about a request can be found.
+func Log(req *http.Request) *logrus.Entry {
+	fields := logrus.Fields{}
+	if id, ok := RequestIDFrom(req.Context()); ok {
+		fields["request_id"] = id
+	}
+	if user, ok := UserFrom(req.Context()); ok {
+		fields["user"] = user.Name
+	}
+	return logrus.WithFields(fields)
+}
+
+// RedactHeaders returns a copy of header that is safe to log, without credentials.
+func RedactHeaders(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for k, v := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+			result[k] = []string{redacted}
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// RedactURL returns u as a string that is safe to log, without a password or query parameters that hold secrets.
+func RedactURL(u *url.URL) string {
+	redactedURL := *u
+	if _, ok := u.User.Password(); u.User != nil && ok {
+		redactedURL.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		changed := false
+		for k := range query {
+			for _, param := range sensitiveParams {
+				if strings.EqualFold(k, param) {
+					query[k] = []string{redacted}
+					changed = true
+				}
+			}
+		}
+		if changed {
+			redactedURL.RawQuery = query.Encode()
+		}
+	}
+	return redactedURL.String()
+}
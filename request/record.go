@@ -0,0 +1,53 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Record collects what inner handlers learn about a request, such as the authenticated user, for the handlers
+// wrapping them, which can't see the context values inner handlers add.
+type Record struct {
+	m       sync.Mutex
+	user    *User
+	backend string
+}
+
+// WithRecord adds a Record to the request, to be read once next has handled it. A request that already has one
+// keeps it, so that every handler wrapping the same request sees what inner handlers record.
+func WithRecord(req *http.Request) (*http.Request, *Record) {
+	if r, ok := RecordFrom(req.Context()); ok {
+		return req, r
+	}
+	r := &Record{}
+	return req.WithContext(context.WithValue(req.Context(), recordKey, r)), r
+}
+
+func RecordFrom(ctx context.Context) (*Record, bool) {
+	r, ok := ctx.Value(recordKey).(*Record)
+	return r, ok
+}
+
+// User returns the user the request was authenticated as, if it was.
+func (r *Record) User() (*User, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.user, r.user != nil
+}
+
+// Backend returns the backend host the request was sent to, if it was.
+func (r *Record) Backend() string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.backend
+}
+
+// SetBackend records the backend host the request is sent to in its Record, if it has one.
+func SetBackend(ctx context.Context, host string) {
+	if r, ok := RecordFrom(ctx); ok {
+		r.m.Lock()
+		defer r.m.Unlock()
+		r.backend = host
+	}
+}
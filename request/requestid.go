@@ -0,0 +1,50 @@
+package request
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the request ID to the backend and back to the client.
+const RequestIDHeader = "X-Request-Id"
+
+// Longest request ID accepted from clients
+const maxRequestIDLength = 128
+
+// WithRequestIDHandler puts a request ID in the request context, taken from the X-Request-Id header when the
+// client sent a valid one and otherwise generated. The header is set on the request, so it is forwarded to the
+// backend, and on the response.
+func WithRequestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			req.Header.Set(RequestIDHeader, id)
+		}
+		rw.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(rw, req.WithContext(WithRequestID(req.Context(), id)))
+	})
+}
+
+// validRequestID allows IDs that are safe to log and echo: letters, digits and a little punctuation.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,140 @@
+package request
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Info describes a request to the kubernetes API, loosely following the RequestInfo the apiserver builds.
+// It is only used for classifying requests (metrics, limits), never for authorization.
+type Info struct {
+	IsResourceRequest bool
+	Verb              string
+	APIGroup          string
+	APIVersion        string
+	Namespace         string
+	Resource          string
+	Subresource       string
+	Name              string
+}
+
+var (
+	apiPrefixes          = map[string]bool{"api": true, "apis": true}
+	namespaceSubresource = map[string]bool{"status": true, "finalize": true}
+)
+
+// NewInfo classifies req. Paths that aren't resource paths produce an Info whose verb is the lower cased HTTP method.
+func NewInfo(req *http.Request) *Info {
+	info := &Info{
+		Verb: strings.ToLower(req.Method),
+	}
+
+	parts := splitPath(req.URL.Path)
+	if len(parts) < 3 || !apiPrefixes[parts[0]] {
+		return info
+	}
+	prefix := parts[0]
+	parts = parts[1:]
+
+	if prefix == "api" {
+		info.APIVersion = parts[0]
+		parts = parts[1:]
+	} else {
+		if len(parts) < 3 {
+			return info
+		}
+		info.APIGroup = parts[0]
+		info.APIVersion = parts[1]
+		parts = parts[2:]
+	}
+
+	info.IsResourceRequest = true
+	switch req.Method {
+	case http.MethodPost:
+		info.Verb = "create"
+	case http.MethodGet, http.MethodHead:
+		info.Verb = "get"
+	case http.MethodPut:
+		info.Verb = "update"
+	case http.MethodPatch:
+		info.Verb = "patch"
+	case http.MethodDelete:
+		info.Verb = "delete"
+	}
+
+	// Deprecated /watch/ path prefix
+	if parts[0] == "watch" {
+		if info.Verb == "get" {
+			info.Verb = "watch"
+		}
+		parts = parts[1:]
+		if len(parts) == 0 {
+			return info
+		}
+	}
+
+	if parts[0] == "namespaces" {
+		if len(parts) > 1 {
+			info.Namespace = parts[1]
+			// /namespaces/{name}/{subresource} is a subresource of the namespace itself
+			if len(parts) > 2 && !namespaceSubresource[parts[2]] {
+				parts = parts[2:]
+			}
+		}
+	}
+
+	switch {
+	case len(parts) >= 3:
+		info.Subresource = parts[2]
+		fallthrough
+	case len(parts) == 2:
+		info.Name = parts[1]
+		fallthrough
+	case len(parts) == 1:
+		info.Resource = parts[0]
+	}
+
+	if info.Resource == "namespaces" {
+		info.Namespace = ""
+	}
+
+	if info.Name == "" && info.Verb == "get" {
+		info.Verb = "list"
+	}
+	if info.Verb == "list" && isWatch(req) {
+		info.Verb = "watch"
+	}
+	if info.Name == "" && info.Verb == "delete" {
+		info.Verb = "deletecollection"
+	}
+
+	return info
+}
+
+func isWatch(req *http.Request) bool {
+	v := strings.ToLower(req.URL.Query().Get("watch"))
+	return v == "true" || v == "1"
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+var (
+	longRunningVerbs        = map[string]bool{"watch": true, "proxy": true}
+	longRunningSubresources = map[string]bool{"attach": true, "exec": true, "proxy": true, "log": true, "portforward": true}
+	readOnlyVerbs           = map[string]bool{"get": true, "list": true, "watch": true, "head": true, "options": true}
+)
+
+// IsLongRunning reports whether the request streams for an unbounded time, like watches and exec sessions.
+func (i *Info) IsLongRunning() bool {
+	return longRunningVerbs[i.Verb] || (i.IsResourceRequest && longRunningSubresources[i.Subresource])
+}
+
+func (i *Info) IsReadOnly() bool {
+	return readOnlyVerbs[i.Verb]
+}
@@ -0,0 +1,42 @@
+package responsewriters
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WriteStatus writes err as a kubernetes Status object, so that kubectl and client-go can show a meaningful error.
+func WriteStatus(rw http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.ErrStatus
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+
+	if status.Details != nil && status.Details.RetryAfterSeconds > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(status.Details.RetryAfterSeconds)))
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(int(status.Code))
+	if err := json.NewEncoder(rw).Encode(status); err != nil {
+		logrus.Debugf("Error writing status response: %v", err)
+	}
+}
+
+// TooManyRequests tells the client to back off for retryAfter seconds.
+func TooManyRequests(rw http.ResponseWriter, message string, retryAfter int) {
+	WriteStatus(rw, apierrors.NewTooManyRequests(message, retryAfter))
+}
+
+// Forbidden rejects a request the proxy refuses to serve.
+func Forbidden(rw http.ResponseWriter, message string) {
+	WriteStatus(rw, &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: message,
+	}})
+}
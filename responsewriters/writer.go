@@ -0,0 +1,74 @@
+package responsewriters
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ResponseWriter wraps an http.ResponseWriter, recording the status code and the number of bytes written.
+// It passes through the optional interfaces the reverse proxy relies on for streaming and upgrades.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func Wrap(rw http.ResponseWriter) *ResponseWriter {
+	if w, ok := rw.(*ResponseWriter); ok {
+		return w
+	}
+	return &ResponseWriter{ResponseWriter: rw}
+}
+
+// Status returns the status code sent to the client, or 200 if the handler wrote a body without calling WriteHeader.
+// Zero means nothing has been written yet.
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *ResponseWriter) BytesWritten() int64 {
+	return w.written
+}
+
+func (w *ResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *ResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *ResponseWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("underlying ResponseWriter doesn't support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rancher/authn-proxy/request"
+)
+
+// Drainer tracks whether the process is shutting down. http.Server.Shutdown waits for active requests to finish,
+// but watches and exec sessions may never finish on their own, so once draining starts long running requests
+// have their context cancelled. The reverse proxy then ends the backend request and completes the response to
+// the client, which client-go treats like any other closed watch and re-establishes against another replica.
+type Drainer struct {
+	draining int32
+	once     sync.Once
+	ch       chan struct{}
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{
+		ch: make(chan struct{}),
+	}
+}
+
+// Drain starts draining. It is safe to call more than once.
+func (d *Drainer) Drain() {
+	d.once.Do(func() {
+		atomic.StoreInt32(&d.draining, 1)
+		close(d.ch)
+	})
+}
+
+func (d *Drainer) Draining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+// Wrap cancels long running requests handled by next once draining starts.
+func (d *Drainer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !request.NewInfo(req).IsLongRunning() {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-d.ch:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		next.ServeHTTP(rw, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RedirectHandler sends every request to the https server, keeping the path and query. The target is
+// externalHost if set, otherwise the host the client asked for with the port of httpsHost. Only a TCP httpsHost has
+// a port clients can be sent to, so for unix sockets and inherited sockets externalHost is required.
+func RedirectHandler(httpsHost, externalHost string, code int) (http.Handler, error) {
+	var port string
+	if externalHost == "" {
+		address := strings.TrimPrefix(httpsHost, "tcp://")
+		if strings.Contains(address, "://") {
+			return nil, errors.Errorf("frontend.https.host %v isn't a TCP address, so frontend.http.redirect.host must be set to "+
+				"the host clients reach the https server at", httpsHost)
+		}
+		var err error
+		if _, port, err = net.SplitHostPort(address); err != nil {
+			return nil, errors.Wrapf(err, "couldn't get the port to redirect to from frontend.https.host %v", httpsHost)
+		}
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		host := externalHost
+		if host == "" {
+			// A bare IPv6 literal like [::1] doesn't split, so drop its brackets to match what SplitHostPort returns
+			host = strings.TrimSuffix(strings.TrimPrefix(req.Host, "["), "]")
+			if h, _, err := net.SplitHostPort(req.Host); err == nil {
+				host = h
+			}
+			if port != "" && port != "443" {
+				host = net.JoinHostPort(host, port)
+			} else if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		}
+		http.Redirect(rw, req, "https://"+host+req.URL.RequestURI(), code)
+	}), nil
+}
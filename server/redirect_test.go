@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		httpsHost, externalHost string
+		requestHost, target     string
+	}{
+		{":9443", "", "proxy.example.com", "https://proxy.example.com:9443/api/v1/pods?watch=true"},
+		{":9443", "", "proxy.example.com:8080", "https://proxy.example.com:9443/api/v1/pods?watch=true"},
+		{":443", "", "proxy.example.com:80", "https://proxy.example.com/api/v1/pods?watch=true"},
+		{"tcp://0.0.0.0:9443", "", "proxy.example.com", "https://proxy.example.com:9443/api/v1/pods?watch=true"},
+		{"tcp://:443", "", "proxy.example.com", "https://proxy.example.com/api/v1/pods?watch=true"},
+		{":9443", "", "[::1]:80", "https://[::1]:9443/api/v1/pods?watch=true"},
+		{":9443", "", "[::1]", "https://[::1]:9443/api/v1/pods?watch=true"},
+		{":443", "", "[::1]:80", "https://[::1]/api/v1/pods?watch=true"},
+		{":9443", "external.example.com", "proxy.example.com", "https://external.example.com/api/v1/pods?watch=true"},
+		{"unix:///run/authn-proxy.sock", "external.example.com:8443", "proxy.example.com", "https://external.example.com:8443/api/v1/pods?watch=true"},
+		{"systemd://https", "external.example.com", "proxy.example.com", "https://external.example.com/api/v1/pods?watch=true"},
+	}
+
+	for _, tt := range tests {
+		h, err := RedirectHandler(tt.httpsHost, tt.externalHost, http.StatusPermanentRedirect)
+		if err != nil {
+			t.Errorf("%v: %v", tt.httpsHost, err)
+			continue
+		}
+		req := httptest.NewRequest("GET", "http://"+tt.requestHost+"/api/v1/pods?watch=true", nil)
+		req.Host = tt.requestHost
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != http.StatusPermanentRedirect {
+			t.Errorf("%v, %v: got status %v", tt.httpsHost, tt.requestHost, rw.Code)
+		}
+		if got := rw.Header().Get("Location"); got != tt.target {
+			t.Errorf("%v, %v: redirected to %v, expected %v", tt.httpsHost, tt.requestHost, got, tt.target)
+		}
+	}
+}
+
+func TestRedirectHandlerNeedsHostForSockets(t *testing.T) {
+	for _, httpsHost := range []string{"unix:///run/authn-proxy.sock", "fd://3", "systemd://", "systemd://https", "no-port"} {
+		if _, err := RedirectHandler(httpsHost, "", http.StatusPermanentRedirect); err == nil {
+			t.Errorf("%v: expected an error without a redirect host", httpsHost)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package throttle
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/rancher/authn-proxy/config"
+	"github.com/rancher/authn-proxy/metrics"
+	"github.com/rancher/authn-proxy/request"
+	"github.com/rancher/authn-proxy/responsewriters"
+)
+
+// Retry-After sent when an in flight limit is hit, same as the apiserver
+const inFlightRetryAfter = 1
+
+// NewHandler limits the requests passed to next. Every request to the backend carries the proxy's own
+// privileged token, so one misbehaving client must not be able to use up the backend's capacity:
+// - per user (and optionally per group) token bucket rate limits
+// - limits on the number of read-only, mutating and long-running requests in flight, like max-in-flight
+// It must run after authentication, since it keys on the user in the request context.
+func NewHandler(ctx context.Context, next http.Handler) (http.Handler, error) {
+	c := config.GetManager(ctx)
+	h := &handler{
+		next: next,
+	}
+
+	userQPS, err := c.GetFloat("ratelimit.user.qps", 0)
+	if err != nil {
+		return nil, err
+	}
+	userBurst, err := c.GetInt("ratelimit.user.burst", 0)
+	if err != nil {
+		return nil, err
+	}
+	if userQPS > 0 {
+		h.users = newRateLimiter(ctx, userQPS, int64(userBurst))
+	}
+
+	groupQPS, err := c.GetFloat("ratelimit.group.qps", 0)
+	if err != nil {
+		return nil, err
+	}
+	groupBurst, err := c.GetInt("ratelimit.group.burst", 0)
+	if err != nil {
+		return nil, err
+	}
+	if groupQPS > 0 {
+		h.groups = newRateLimiter(ctx, groupQPS, int64(groupBurst))
+		h.limitedGroups = map[string]bool{}
+		for _, g := range c.GetList("ratelimit.groups") {
+			h.limitedGroups[g] = true
+		}
+	}
+
+	for kind, limit := range map[string]**inFlightLimiter{
+		"readonly":    &h.readOnly,
+		"mutating":    &h.mutating,
+		"longrunning": &h.longRunning,
+	} {
+		max, err := c.GetInt("maxinflight."+kind, 0)
+		if err != nil {
+			return nil, err
+		}
+		if max > 0 {
+			*limit = newInFlightLimiter(kind, max)
+		}
+	}
+
+	return h, nil
+}
+
+type handler struct {
+	next          http.Handler
+	m             sync.Mutex
+	users         *rateLimiter
+	groups        *rateLimiter
+	limitedGroups map[string]bool
+	readOnly      *inFlightLimiter
+	mutating      *inFlightLimiter
+	longRunning   *inFlightLimiter
+}
+
+func (h *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if user, ok := request.UserFrom(req.Context()); ok {
+		if ok, retryAfter := h.takeToken(user); !ok {
+			metrics.ThrottledRequests.WithLabelValues("ratelimit").Inc()
+			request.Log(req).Debugf("Rate limiting user %v", user.Name)
+			responsewriters.TooManyRequests(rw, "Too many requests, please try again later.", retryAfter)
+			return
+		}
+	}
+
+	info := request.NewInfo(req)
+	limiter := h.mutating
+	if info.IsLongRunning() {
+		limiter = h.longRunning
+	} else if info.IsReadOnly() {
+		limiter = h.readOnly
+	}
+	if limiter != nil {
+		if !limiter.acquire() {
+			metrics.ThrottledRequests.WithLabelValues("maxinflight-" + limiter.kind).Inc()
+			responsewriters.TooManyRequests(rw, "Too many requests, please try again later.", inFlightRetryAfter)
+			return
+		}
+		defer limiter.release()
+	}
+
+	h.next.ServeHTTP(rw, req)
+}
+
+// takeToken takes a token from the user's bucket and from those of its limited groups. Nothing is taken unless every
+// bucket has a token, so a request rejected by one limit doesn't count against the others.
+func (h *handler) takeToken(user *request.User) (bool, int) {
+	type limit struct {
+		limiter *rateLimiter
+		key     string
+	}
+	var limits []limit
+	if h.users != nil {
+		limits = append(limits, limit{h.users, user.Name})
+	}
+	if h.groups != nil {
+		for _, g := range user.Groups {
+			if len(h.limitedGroups) > 0 && !h.limitedGroups[g] {
+				continue
+			}
+			limits = append(limits, limit{h.groups, g})
+		}
+	}
+
+	// Concurrent requests of the same user or group must not both pass the check and then take the last token
+	h.m.Lock()
+	defer h.m.Unlock()
+	for _, l := range limits {
+		if ok, retryAfter := l.limiter.available(l.key); !ok {
+			return false, retryAfter
+		}
+	}
+	for _, l := range limits {
+		l.limiter.take(l.key)
+	}
+	return true, 0
+}
+
+type inFlightLimiter struct {
+	kind string
+	sem  chan struct{}
+}
+
+func newInFlightLimiter(kind string, max int) *inFlightLimiter {
+	return &inFlightLimiter{
+		kind: kind,
+		sem:  make(chan struct{}, max),
+	}
+}
+
+func (l *inFlightLimiter) acquire() bool {
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *inFlightLimiter) release() {
+	<-l.sem
+}
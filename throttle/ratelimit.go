@@ -0,0 +1,90 @@
+package throttle
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+const (
+	bucketIdleTimeout = 10 * time.Minute
+	cleanupInterval   = time.Minute
+)
+
+// rateLimiter keeps a token bucket per key (a user or group name). Buckets that haven't been used in a while are
+// full again anyway, so they are dropped to keep memory bounded.
+type rateLimiter struct {
+	qps     float64
+	burst   int64
+	m       sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens   *ratelimit.Bucket
+	lastUsed time.Time
+}
+
+func newRateLimiter(ctx context.Context, qps float64, burst int64) *rateLimiter {
+	if burst <= 0 {
+		burst = int64(math.Ceil(qps))
+	}
+	r := &rateLimiter{
+		qps:     qps,
+		burst:   burst,
+		buckets: map[string]*bucket{},
+	}
+	go r.cleanup(ctx)
+	return r
+}
+
+// available reports whether key's bucket has a token. If it doesn't it returns false and the number of seconds until
+// it will.
+func (r *rateLimiter) available(key string) (bool, int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.bucket(key).tokens.Available() >= 1 {
+		return true, 0
+	}
+	return false, int(math.Ceil(1 / r.qps))
+}
+
+// take removes a token from key's bucket, which available must have reported to have one.
+func (r *rateLimiter) take(key string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.bucket(key).tokens.TakeAvailable(1)
+}
+
+// bucket returns key's bucket, creating it if needed. r.m must be held.
+func (r *rateLimiter) bucket(key string) *bucket {
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: ratelimit.NewBucketWithRate(r.qps, r.burst)}
+		r.buckets[key] = b
+	}
+	b.lastUsed = time.Now()
+	return b
+}
+
+func (r *rateLimiter) cleanup(ctx context.Context) {
+	t := time.NewTicker(cleanupInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.m.Lock()
+			for k, b := range r.buckets {
+				if time.Since(b.lastUsed) > bucketIdleTimeout {
+					delete(r.buckets, k)
+				}
+			}
+			r.m.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
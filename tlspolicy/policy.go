@@ -0,0 +1,198 @@
+package tlspolicy
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/authn-proxy/config"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	ProfileModern       = "modern"
+	ProfileIntermediate = "intermediate"
+
+	http2Proto = "h2"
+)
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuites are the configurable suites by name, and insecureCipherSuites those that are rejected, such as RC4
+// and 3DES. TLS 1.3 suites aren't configurable in Go, so they're left out.
+var cipherSuites, insecureCipherSuites = map[string]uint16{}, map[string]bool{}
+
+var curves = map[string]tls.CurveID{
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+	"X25519": tls.X25519,
+}
+
+// profile is a named starting point, following Mozilla's server side TLS recommendations of the same name.
+type profile struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+// aeadCipherSuites are the ECDHE suites with AES-GCM or ChaCha20, which HTTP/2 requires to come first.
+var aeadCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+var profiles = map[string]profile{
+	// TLS 1.3 only, whose cipher suites Go chooses
+	ProfileModern: {
+		minVersion: tls.VersionTLS13,
+		curves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+	// TLS 1.2 with ECDHE AEAD suites, and TLS 1.3
+	ProfileIntermediate: {
+		minVersion:   tls.VersionTLS12,
+		cipherSuites: aeadCipherSuites,
+		curves:       []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+}
+
+// New builds the TLS config for one side of the proxy from the keys under prefix ("frontend.tls" or "backend.tls"):
+// - profile: modern or intermediate, the starting point for the other settings
+// - min.version: 1.0, 1.1, 1.2 or 1.3, no lower than the profile's
+// - cipher.suites: comma separated Go cipher suite names, excluding insecure ones; they only apply below TLS 1.3
+// - curves: comma separated P256, P384, P521 or X25519
+// - http2: whether to offer HTTP/2 with ALPN, defaulting to http2Default
+// Unset keys keep Go's defaults. All invalid values and combinations are reported together.
+func New(c *config.Manager, prefix string, http2Default bool) (*tls.Config, error) {
+	var errs []error
+	tlsConfig := &tls.Config{}
+
+	profileName := c.GetString(prefix+".profile", "")
+	p, ok := profiles[profileName]
+	if profileName != "" {
+		if !ok {
+			errs = append(errs, errors.Errorf("%v.profile: unknown profile %q, expected %v or %v", prefix, profileName, ProfileModern, ProfileIntermediate))
+		}
+		tlsConfig.MinVersion = p.minVersion
+		tlsConfig.CipherSuites = p.cipherSuites
+		tlsConfig.CurvePreferences = p.curves
+	}
+
+	if v := c.GetString(prefix+".min.version", ""); v != "" {
+		version, ok := versions[v]
+		if !ok {
+			errs = append(errs, errors.Errorf("%v.min.version: unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", prefix, v))
+		} else if version < p.minVersion {
+			errs = append(errs, errors.Errorf("%v.min.version: %v is below the minimum of the %v profile", prefix, v, profileName))
+		} else {
+			tlsConfig.MinVersion = version
+		}
+	}
+
+	if names := c.GetList(prefix + ".cipher.suites"); len(names) > 0 {
+		tlsConfig.CipherSuites = nil
+		for _, name := range names {
+			id, ok := cipherSuites[name]
+			if insecureCipherSuites[name] {
+				errs = append(errs, errors.Errorf("%v.cipher.suites: %v is insecure", prefix, name))
+				continue
+			} else if !ok {
+				errs = append(errs, errors.Errorf("%v.cipher.suites: unknown cipher suite %q", prefix, name))
+				continue
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+		if tlsConfig.MinVersion == tls.VersionTLS13 {
+			errs = append(errs, errors.Errorf("%v.cipher.suites: cipher suites can't be configured for TLS 1.3, which is the minimum version", prefix))
+		}
+	}
+
+	if names := c.GetList(prefix + ".curves"); len(names) > 0 {
+		tlsConfig.CurvePreferences = nil
+		for _, name := range names {
+			id, ok := curves[name]
+			if !ok {
+				errs = append(errs, errors.Errorf("%v.curves: unknown curve %q, expected P256, P384, P521 or X25519", prefix, name))
+				continue
+			}
+			tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, id)
+		}
+	}
+
+	http2, err := c.GetBool(prefix+".http2", http2Default)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if http2 {
+		if err := checkHTTP2(tlsConfig); err != nil {
+			errs = append(errs, errors.Wrapf(err, "%v.http2", prefix))
+		}
+		tlsConfig.NextProtos = []string{http2Proto, "http/1.1"}
+	} else {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+// HTTP2 reports whether tlsConfig, as returned by New, offers HTTP/2.
+func HTTP2(tlsConfig *tls.Config) bool {
+	for _, proto := range tlsConfig.NextProtos {
+		if proto == http2Proto {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHTTP2 enforces what HTTP/2 requires of the cipher suites (RFC 7540 section 9.2), which Go's HTTP/2 server
+// refuses to start without: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 must be included, and ephemeral AEAD suites must
+// be listed before all others.
+func checkHTTP2(tlsConfig *tls.Config) error {
+	if len(tlsConfig.CipherSuites) == 0 {
+		return nil
+	}
+	haveRequired, sawBad := false, false
+	for _, id := range tlsConfig.CipherSuites {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+			haveRequired = true
+		}
+		if !http2Approved[id] {
+			sawBad = true
+		} else if sawBad {
+			return errors.New("HTTP/2 requires ECDHE AES-GCM and ChaCha20 cipher suites to be listed before all others; reorder the cipher suites or disable http2")
+		}
+	}
+	if !haveRequired {
+		return errors.New("HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 in the cipher suites; add it or disable http2")
+	}
+	return nil
+}
+
+var http2Approved = map[uint16]bool{}
+
+func init() {
+	for _, id := range aeadCipherSuites {
+		http2Approved[id] = true
+	}
+	for _, suite := range tls.CipherSuites() {
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			continue
+		}
+		cipherSuites[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecureCipherSuites[suite.Name] = true
+	}
+}